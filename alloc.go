@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+// idRange is a block of IDs reserved by a single allocation, from Start to End inclusive
+type idRange struct {
+	Name  string `json:"name"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	Count int64  `json:"count"`
+}
+
+// String formats the range as start-end
+func (r idRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// allocRange reserves count IDs following the current value of the counter, which always holds the last ID
+// handed out; with align > 1 the block starts one past a multiple of align, and limit > 0 is the largest ID
+func allocRange(name string, count, align, limit int64) (idRange, error) {
+	reserved := idRange{Name: name, Count: count}
+	_, _, err := updateCounter(name, func(current int64) (int64, error) {
+		if current < 0 {
+			current = 0
+		}
+		if align > 1 && current%align != 0 {
+			if current > math.MaxInt64-align {
+				return current, errors.New("ID space is exhausted")
+			}
+			current += align - current%align
+		}
+		available := int64(math.MaxInt64) - current
+		if limit > 0 {
+			available = max(limit-current, 0)
+		}
+		if available < count {
+			return current, fmt.Errorf("ID space is exhausted: %d of %d IDs left", available, count)
+		}
+		reserved.Start, reserved.End = current+1, current+count
+		return reserved.End, nil
+	})
+	return reserved, err
+}
+
+// runAlloc reserves a block of IDs in one atomic step and prints it as start-end or JSON
+func runAlloc(args []string) error {
+	var (
+		count    int64
+		align    int64
+		limit    int64
+		jsonMode bool
+	)
+	fs := newCommandFlags("alloc")
+	fs.Int64Var(&count, "count", 1, "number of IDs to reserve")
+	fs.Int64Var(&align, "align", 0, "start blocks one past a multiple of this size, such as 1000")
+	fs.Int64Var(&limit, "max", 0, "largest ID that may be handed out (0 is unlimited)")
+	fs.BoolVar(&jsonMode, "json", false, "print the range as JSON with start and end")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: counter alloc <name> -count N [-align N] [-max N] [-json]")
+	}
+	if count <= 0 {
+		return errors.New("-count must be positive")
+	}
+	if align < 0 || limit < 0 {
+		return errors.New("-align and -max cannot be negative")
+	}
+	if neverAdd {
+		return errors.New("add operation is disabled by the environment variable")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	name := positional[0]
+	reserved, allocErr := allocRange(name, count, align, limit)
+	if allocErr != nil {
+		return fmt.Errorf("counter %s: %w", name, allocErr)
+	}
+	if jsonMode {
+		return json.NewEncoder(os.Stdout).Encode(reserved)
+	}
+	fmt.Println(reserved)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestAllocRange tests that consecutive allocations hand out adjacent blocks
+func TestAllocRange(t *testing.T) {
+	useCounterDir(t)
+	first, err := allocRange("ids", 5000, 0, 0)
+	if err != nil {
+		t.Fatalf("allocRange failed: %v", err)
+	}
+	second, err := allocRange("ids", 1000, 0, 0)
+	if err != nil {
+		t.Fatalf("allocRange failed: %v", err)
+	}
+	if first.String() != "1-5000" || second.String() != "5001-6000" {
+		t.Errorf("Expected 1-5000 and 5001-6000, got %s and %s", first, second)
+	}
+	if value, _ := readCounter(counterPath("ids")); value != 6000 {
+		t.Errorf("Expected the counter to hold the last ID 6000, got %d", value)
+	}
+}
+
+// TestAllocRangeAlign tests that aligned blocks skip to the next multiple
+func TestAllocRangeAlign(t *testing.T) {
+	useCounterDir(t)
+	if _, err := allocRange("ids", 10, 0, 0); err != nil {
+		t.Fatalf("allocRange failed: %v", err)
+	}
+	reserved, err := allocRange("ids", 100, 100, 0)
+	if err != nil {
+		t.Fatalf("allocRange failed: %v", err)
+	}
+	if reserved.String() != "101-200" {
+		t.Errorf("Expected 101-200, got %s", reserved)
+	}
+}
+
+// TestAllocRangeMax tests that allocations fail once the ID space is exhausted
+func TestAllocRangeMax(t *testing.T) {
+	useCounterDir(t)
+	if _, err := allocRange("ids", 60, 0, 100); err != nil {
+		t.Fatalf("allocRange failed: %v", err)
+	}
+	if _, err := allocRange("ids", 60, 0, 100); err == nil {
+		t.Fatalf("Expected the allocation to fail with 40 IDs left")
+	}
+	reserved, err := allocRange("ids", 40, 0, 100)
+	if err != nil || reserved.String() != "61-100" {
+		t.Errorf("Expected 61-100, got %s (%v)", reserved, err)
+	}
+	if value, _ := readCounter(counterPath("ids")); value != 100 {
+		t.Errorf("Expected a failed allocation to leave the counter alone, got %d", value)
+	}
+}
+
+// TestAllocRangeConcurrent tests that concurrent allocations never overlap
+func TestAllocRangeConcurrent(t *testing.T) {
+	useCounterDir(t)
+	const workers = 10
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		taken = make(map[int64]bool)
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reserved, err := allocRange("ids", 50, 0, 0)
+			if err != nil {
+				t.Errorf("allocRange failed: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for id := reserved.Start; id <= reserved.End; id++ {
+				if taken[id] {
+					t.Errorf("ID %d was allocated twice", id)
+				}
+				taken[id] = true
+			}
+		}()
+	}
+	wg.Wait()
+	if len(taken) != workers*50 {
+		t.Errorf("Expected %d IDs, got %d", workers*50, len(taken))
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// UpdateVariable is the variable that holds the current value of the counter in an update expression
+const UpdateVariable string = "x"
+
+// parseUpdate parses an expression that computes the new value of a counter from its current value x
+func parseUpdate(text string) (expr, error) {
+	e, err := parseExpr(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range exprRefs(e) {
+		if ref != UpdateVariable {
+			return nil, fmt.Errorf("unknown variable %s in %q: the current value is %s", ref, text, UpdateVariable)
+		}
+	}
+	return e, nil
+}
+
+// applyUpdate evaluates an update expression against the current value with the same policy as -add
+// and -sub: a result above the current value is an addition and a result below it a subtraction
+func applyUpdate(e expr, current int64) (int64, error) {
+	next, err := e.eval(func(string) (int64, error) { return current, nil })
+	if err != nil {
+		return current, err
+	}
+	if next > current && neverAdd {
+		return current, errors.New("add operation is disabled by the environment variable")
+	}
+	if next < current && neverSubtract {
+		return current, errors.New("subtract operation is disabled by the environment variable")
+	}
+	return next, nil
+}
+
+// runApply replaces the value of a counter with the result of an expression of its current value
+func runApply(args []string) error {
+	var text string
+	fs := newCommandFlags("apply")
+	fs.StringVar(&text, "expr", "", "expression of the current value x, such as 'max(x, 500)' or 'x * 2'")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 1 || text == "" {
+		return errors.New("usage: counter apply <name> -expr '<expression>'")
+	}
+	e, exprErr := parseUpdate(text)
+	if exprErr != nil {
+		return exprErr
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	name := positional[0]
+	_, value, updateErr := updateCounter(name, func(current int64) (int64, error) {
+		return applyUpdate(e, current)
+	})
+	if updateErr != nil {
+		return fmt.Errorf("counter %s: %w", name, updateErr)
+	}
+	fmt.Println(value)
+	return nil
+}
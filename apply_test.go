@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestParseUpdate tests that update expressions may only refer to the current value
+func TestParseUpdate(t *testing.T) {
+	if _, err := parseUpdate("max(x, 500)"); err != nil {
+		t.Errorf("Expected max(x, 500) to parse, got %v", err)
+	}
+	if _, err := parseUpdate("x + builds"); err == nil {
+		t.Errorf("Expected a reference to another counter to be rejected")
+	}
+}
+
+// TestApplyUpdate tests evaluating update expressions and the add and subtract policies
+func TestApplyUpdate(t *testing.T) {
+	t.Cleanup(func() { neverAdd, neverSubtract = DefaultNeverAdd, DefaultNeverSubtract })
+	tests := []struct {
+		text    string
+		current int64
+		want    int64
+	}{
+		{"x * 2", 21, 42},
+		{"max(x, 500)", 42, 500},
+		{"x % 7", 30, 2},
+	}
+	for _, tt := range tests {
+		e, err := parseUpdate(tt.text)
+		if err != nil {
+			t.Fatalf("parseUpdate(%q) failed: %v", tt.text, err)
+		}
+		if got, err := applyUpdate(e, tt.current); err != nil || got != tt.want {
+			t.Errorf("Expected %q of %d to be %d, got %d (%v)", tt.text, tt.current, tt.want, got, err)
+		}
+	}
+
+	half, _ := parseUpdate("x / 2")
+	neverSubtract = true
+	if _, err := applyUpdate(half, 10); err == nil {
+		t.Errorf("Expected a decrease to be rejected when subtraction is disabled")
+	}
+	if got, err := applyUpdate(half, 0); err != nil || got != 0 {
+		t.Errorf("Expected an unchanged value to be allowed, got %d (%v)", got, err)
+	}
+	double, _ := parseUpdate("x * 2")
+	neverAdd = true
+	if _, err := applyUpdate(double, 10); err == nil {
+		t.Errorf("Expected an increase to be rejected when adding is disabled")
+	}
+	neverAdd = false
+	if _, err := applyUpdate(double, 1<<62); err == nil {
+		t.Errorf("Expected an overflow to be rejected")
+	}
+}
+
+// TestApplyCounter tests applying an expression to a stored counter
+func TestApplyCounter(t *testing.T) {
+	useCounterDir(t)
+	setCounters(t, map[string]int64{"builds": 42})
+	e, _ := parseUpdate("max(x, 500)")
+	_, value, err := updateCounter("builds", func(current int64) (int64, error) { return applyUpdate(e, current) })
+	if err != nil || value != 500 {
+		t.Errorf("Expected 500, got %d (%v)", value, err)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const AuditFile string = ".audit.log"
+
+// auditLog appends a timestamped entry to the audit log in dir; continuation lines of a multi-line
+// entry are indented so that every entry starts with its timestamp
+func auditLog(dir, format string, args ...interface{}) error {
+	entry := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	entry = strings.ReplaceAll(entry, "\n", "\n    | ")
+	line := fmt.Sprintf("%s %s\n", time.Now().Format(time.RFC3339), entry)
+	file, openErr := os.OpenFile(filepath.Join(dir, AuditFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if openErr != nil {
+		return openErr
+	}
+	defer file.Close()
+	_, writeErr := file.WriteString(line)
+	return writeErr
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Commands maps subcommand names to the functions that run them. Each function
+// receives the arguments that follow the subcommand name on the command line.
+var Commands = map[string]func(args []string) error{
+	"add":       runAdd,
+	"alloc":     runAlloc,
+	"apply":     runApply,
+	"derive":    runDerive,
+	"export":    runExport,
+	"get":       runGet,
+	"git":       runGitCommand,
+	"hook":      runHookCommand,
+	"label":     runLabel,
+	"list":      runList,
+	"merge":     runMerge,
+	"next":      runNext,
+	"observe":   runObserve,
+	"rate":      runRate,
+	"ratelimit": runRateLimit,
+	"reset":     runReset,
+	"schedule":  runSchedule,
+	"sem":       runSemaphore,
+	"sum":       runSum,
+	"sync":      runSync,
+	"top":       runTop,
+	"tree":      runTree,
+	"type":      runType,
+	"uadd":      runUniqueAdd,
+	"ui":        runUIDashboard,
+	"unit":      runUnit,
+	"wait":      runWait,
+	"watch":     runWatch,
+	"webhook":   runWebhookCommand,
+}
+
+// runCommand runs the subcommand named by args[0] and exits when one is registered
+func runCommand(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	command, ok := Commands[args[0]]
+	if !ok {
+		return
+	}
+	handleEnvironment()
+	if err := command(args[1:]); err != nil {
+		var exitErr exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// exitCodeError makes runCommand exit with Code without printing anything, for commands that
+// pass on the exit status of a program they ran
+type exitCodeError struct {
+	Code int
+}
+
+// Error describes the exit status
+func (e exitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// newCommandFlags returns a flag set for a subcommand with the directory flags shared by every subcommand
+func newCommandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("counter "+name, flag.ContinueOnError)
+	fs.StringVar(&counterDir, "d", counterDir, "counter directory")
+	fs.StringVar(&counterDir, "dir", counterDir, "counter directory")
+	fs.BoolVar(&useForce, "F", useForce, "force overwrite")
+	fs.BoolVar(&useForce, "force", useForce, "force overwrite")
+	return fs
+}
+
+// parseCommandArgs parses flags that appear before or after positional arguments and returns the positional arguments
+func parseCommandArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// prepareCounterDir ensures counterDir exists and resolves it when it is a symlink
+func prepareCounterDir() error {
+	if err := ensureDir(counterDir, useForce); err != nil {
+		return err
+	}
+	if resolved, resolveErr := resolveSymlink(counterDir); resolveErr == nil {
+		counterDir = resolved
+	}
+	return nil
+}
+
+// counterPath returns the path of the file that stores the named counter, preferring a
+// file named after the counter itself when one was written by an earlier release
+func counterPath(name string) string {
+	hashed := filepath.Join(counterDir, generateCounterFileName(name))
+	if _, err := os.Stat(hashed); err == nil {
+		return hashed
+	}
+	legacy := filepath.Join(counterDir, name)
+	if filepath.Dir(legacy) == filepath.Clean(counterDir) {
+		if info, err := os.Stat(legacy); err == nil && info.Mode().IsRegular() {
+			return legacy
+		}
+	}
+	return hashed
+}
@@ -36,6 +36,7 @@ const (
 	DefaultNeverReset    bool   = false
 	DefaultNeverAdd      bool   = false
 	DefaultNeverSetTo    bool   = false
+	DefaultUpdateExpr    string = ""
 )
 
 var (
@@ -58,6 +59,7 @@ var (
 	neverAdd             = DefaultNeverAdd
 	setTo         int64  = DefaultSetTo
 	neverSetTo    bool   = DefaultNeverSetTo
+	updateExpr    string = DefaultUpdateExpr
 )
 
 var CounterEnv = map[string]interface{}{
@@ -70,6 +72,7 @@ var CounterEnv = map[string]interface{}{
 	"COUNTER_NEVER_DELETE":   &neverDelete,
 	"COUNTER_NEVER_SET_TO":   &neverSetTo,
 	"COUNTER_NEVER_SUBTRACT": &neverSubtract,
+	"COUNTER_NODE_ID":        &nodeID,
 }
 
 // handleEnvironment sets properties based on environment variables
@@ -99,14 +102,16 @@ func handleEnvironment() {
 }
 
 func main() {
+	runCommand(os.Args[1:])
+
 	// Shorthand
 	flag.BoolVar(&doAdd, "a", DefaultDoAdd, "add -q=N (1) to the counter")
 	flag.BoolVar(&doSub, "s", DefaultDoSub, "subtract -q=N (1) from the counter")
-	flag.Int64Var(&setTo, "S", DefaultSetTo, "set counter to value - 0 value ignores this flag")
+	flag.Var(numberFlag{&setTo, &setToText}, "S", "set counter to value - 0 value ignores this flag")
 	flag.BoolVar(&doReset, "R", DefaultDoReset, "set counter to 0")
 	flag.BoolVar(&doDelete, "D", DefaultDoDelete, "delete the counter")
 	flag.BoolVar(&useForce, "F", DefaultUseForce, "force overwrite")
-	flag.Int64Var(&quantity, "q", DefaultQuantity, "quantity to either add/subtract from counter")
+	flag.Var(numberFlag{&quantity, &quantityText}, "q", "quantity to either add/subtract from counter")
 	flag.BoolVar(&showVersion, "v", DefaultShowVersion, "show version")
 	flag.StringVar(&counterDir, "d", DefaultCounterDir, "counter directory")
 	flag.StringVar(&counterFile, "f", DefaultCounterFile, "counter file name")
@@ -115,11 +120,13 @@ func main() {
 	// Longhand
 	flag.BoolVar(&doAdd, "add", doAdd, "add -q=N (1) to the counter")
 	flag.BoolVar(&doSub, "sub", doSub, "subtract -q=N (1) from the counter")
-	flag.Int64Var(&setTo, "set", setTo, "set counter to value - 0 value ignores this flag")
+	flag.Var(numberFlag{&setTo, &setToText}, "set", "set counter to value - 0 value ignores this flag")
 	flag.BoolVar(&useYes, "yes", useYes, "your response is yes")
 	flag.BoolVar(&doReset, "reset", doReset, "reset the counter")
 	flag.BoolVar(&useForce, "force", useForce, "force overwrite")
 	flag.BoolVar(&doDelete, "delete", doDelete, "remove counter (requires -yes)")
+	flag.StringVar(&outputFormat, "format", outputFormat, "print the value as raw, auto, comma, si, iec, hex, pad:N or a template such as '{{.Name}}={{.Value}}'")
+	flag.StringVar(&updateExpr, "expr", updateExpr, "set the counter to an expression of its current value x, such as 'max(x, 500)'")
 	flag.BoolVar(&showUsage, "usage", showUsage, "show usage")
 	flag.StringVar(&counterDir, "dir", counterDir, "counter directory")
 	flag.StringVar(&counterName, "name", counterName, "counter name")
@@ -186,7 +193,9 @@ func main() {
 		fmt.Println("|   -s      | -sub <int64>       | Subtract -q=N (1) from the counter               |")
 		fmt.Println("|   -S=     | -set <int64>       | Set the counter to value -S=0 ignores this flag  |")
 		fmt.Println("|   -R      | -reset             | Reset the counter to 0                           |")
+		fmt.Println("|           | -expr '<expr>'     | Set to an expression of the value x: 'max(x, 5)' |")
 		fmt.Println("|   -D      | -delete            | Delete the counter                               |")
+		fmt.Println("|           | -format <F>        | Print raw, comma, si, iec, hex, pad:N or {{...}} |")
 		fmt.Println("-------------------------------------------------------------------------------------")
 		fmt.Println("")
 		fmt.Println("+++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++")
@@ -196,6 +205,63 @@ func main() {
 		fmt.Println("|   -yes    |                    | Confirm destructive actions on counters          |")
 		fmt.Println("-------------------------------------------------------------------------------------")
 		fmt.Println("")
+		fmt.Println("+++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++")
+		fmt.Println("+                  [COMMANDS] counter <command> <name> [OPTIONS]                    +")
+		fmt.Println("+-----------------------------------------------------------------------------------+")
+		fmt.Println("+ Command   | Options            | Notes                                            +")
+		fmt.Println("+-----------+--------------------+--------------------------------------------------+")
+		fmt.Println("|   wait    | -ge -eq -le <int64>| Block until the counter meets the condition      |")
+		fmt.Println("|           | -changed -timeout  | Block until it changes or give up after duration |")
+		fmt.Println("|   watch   | <pattern> -json    | Stream changes to matching counters              |")
+		fmt.Println("|           | -listen <addr>     | Serve the stream as server-sent events (/events) |")
+		fmt.Println("|   hook    | add <name> -when   | Run -exec when the counter crosses the condition |")
+		fmt.Println("|           | list | remove <id>  | Show or delete hooks                             |")
+		fmt.Println("|   git     | init -remote -push | Commit every change, such as 'builds: 41 -> 42'  |")
+		fmt.Println("|           | pull | push        | Resolve conflicts per counter on pull            |")
+		fmt.Println("|           | strategy <name> S  | Merge by pn, max, min, ours, theirs or union     |")
+		fmt.Println("|   merge   | <other-dir>        | Merge per-node totals, never losing an increment |")
+		fmt.Println("|   sync    | -from <dir> -to    | Reconcile counters, names and metadata of dirs   |")
+		fmt.Println("|           | -bidirectional     | Sync both ways; -dry-run only reports changes    |")
+		fmt.Println("|   add     | <name> -q <int64>  | Add to the counter, -window <duration> buckets   |")
+		fmt.Println("|   get     | <name>             | Print the counter, -window <duration> sums       |")
+		fmt.Println("|           | <name> -p 50,95,99 | Print the percentiles of a histogram counter     |")
+		fmt.Println("|   apply   | <name> -expr E     | Set to an expression of the value x, like 'x * 2'|")
+		fmt.Println("|   sum     | <pattern>          | Total of matching counters, like 'team.api.*'    |")
+		fmt.Println("|   tree    | [namespace]        | Show dotted names as a tree with rollup totals   |")
+		fmt.Println("|   rate    | <name> -over 5m    | Increase per second from the recorded history    |")
+		fmt.Println("|   top     | [pattern] -by rate | Rank counters by rate or value, -n 10 -over 5m   |")
+		fmt.Println("|           | [pattern] -live    | Show the live dashboard of counter ui instead    |")
+		fmt.Println("|   ui      | [pattern] -sort S  | Live table with deltas, rates and sparklines     |")
+		fmt.Println("|   reset   | <pattern> -yes     | Reset every matching counter to 0                |")
+		fmt.Println("|   label   | set <name> k=v ... | Attach key=value labels to the counter           |")
+		fmt.Println("|           | remove | show      | Remove labels by key or print them               |")
+		fmt.Println("|   list    | [pattern] -l sel   | List counters whose labels match env=prod,a!=b   |")
+		fmt.Println("|   unit    | <name> [unit|none] | Count in bytes (iec) or another unit (si) in list|")
+		fmt.Println("|  export   | [pattern] -l sel   | Write matching counters, -format json or prom    |")
+		fmt.Println("|   next    | <name> -format T   | Increment and print an ID like INV-{value:06}    |")
+		fmt.Println("|           | -scope -tombstone  | Per year/month/day sequences, never reuse IDs    |")
+		fmt.Println("|   alloc   | <name> -count N    | Reserve a block of IDs and print it as start-end |")
+		fmt.Println("|           | -align -max -json  | Align blocks, cap the ID space, print JSON       |")
+		fmt.Println("| ratelimit | acquire <name>     | Take a token -rate N/unit -burst N [-nowait]     |")
+		fmt.Println("|           | status <name>      | Show tokens left and refill time                 |")
+		fmt.Println("|   sem     | acquire <name> -max| Run a command once one of N slots is free       |")
+		fmt.Println("|           | -lease D -- <cmd>  | Slots of crashed or expired holders are reclaimed|")
+		fmt.Println("|           | list [pattern]     | Show the holders of each semaphore               |")
+		fmt.Println("| schedule  | set <name>         | Reset lazily -reset-every <period> or -cron expr |")
+		fmt.Println("|           | show|clear|archive | Show, remove or list the archived periods        |")
+		fmt.Println("|   type    | <name> big|decimal | Hold arbitrary integers or -scale N decimals     |")
+		fmt.Println("|           | <name> float       | Hold a gauge printed with -precision N decimals  |")
+		fmt.Println("|           | <name> unique      | Estimate distinct values added with uadd         |")
+		fmt.Println("|           | <name> histogram   | Bucket observations, -buckets or -exponential    |")
+		fmt.Println("|           | <name> decay       | Fade the value by half every -half-life (24h)    |")
+		fmt.Println("|           | <name> [int]       | Show the type, or convert back when it fits      |")
+		fmt.Println("|   uadd    | <name> [value...]  | Count distinct values, read from stdin if none   |")
+		fmt.Println("|  observe  | <name> [value...]  | Record values in a histogram, read stdin if none |")
+		fmt.Println("|  derive   | <name> '<expr>'    | Compute from other counters: 'a * 1000 / b'      |")
+		fmt.Println("|  webhook  | add <name> -url    | POST signed JSON when the counter changes        |")
+		fmt.Println("|           | list|remove|flush  | Manage webhooks or retry pending deliveries      |")
+		fmt.Println("-------------------------------------------------------------------------------------")
+		fmt.Println("")
 		fmt.Println("                            REAL WORLD EXAMPLE USAGE                                 ")
 		fmt.Println("+-----------------------------------------------------------------------------------+")
 		fmt.Println("|  $ go install github.com/andreimerlescu/counter@latest                            |")
@@ -225,27 +291,33 @@ func main() {
 		os.Exit(0)
 	}
 
+	if format, err := parseValueFormat(outputFormat); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	} else {
+		printFormat = format
+	}
+
 	if strings.EqualFold(counterFile, DefaultCounterFile) && strings.EqualFold(counterName, DefaultCounterName) {
 		_, _ = fmt.Fprintf(os.Stderr, "Error: -name or -file is required\n")
 		os.Exit(1)
 	}
 
-	if err := ensureDir(counterDir, useForce); err != nil {
+	if err := prepareCounterDir(); err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
-	if resolved, resolveErr := resolveSymlink(counterFile); resolveErr == nil {
-		counterFile = resolved
-	}
-	if resolved, resolveErr := resolveSymlink(counterDir); resolveErr == nil {
-		counterDir = resolved
+	if counterFile != DefaultCounterFile {
+		if resolved, resolveErr := resolveSymlink(counterFile); resolveErr == nil {
+			counterFile = resolved
+		}
 	}
 	if counterFile == DefaultCounterFile {
 		if counterName == DefaultCounterName {
 			_, _ = fmt.Fprintf(os.Stderr, "Error: counter name is required\n")
 			os.Exit(1)
 		}
-		counterFile = filepath.Join(counterDir, generateCounterFileName(counterName))
+		counterFile = counterPath(counterName)
 	} else {
 		if counterName == "" {
 			if counterFile[0] != '/' {
@@ -255,7 +327,32 @@ func main() {
 			counterFile = filepath.Join(counterDir, counterName)
 		}
 	}
-	counter, readErr := readCounter(counterFile)
+	unlock, lockErr := lockFile(lockPath(counterFile))
+	if lockErr != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", lockErr)
+		os.Exit(1)
+	}
+	meta, metaErr := readMeta(counterFile)
+	if metaErr != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", metaErr)
+		os.Exit(1)
+	}
+	var (
+		counter int64
+		shown   string
+		readErr error
+	)
+	if meta.typed() {
+		if shown, readErr = readStored(counterFile, meta); readErr == nil {
+			shown = meta.display(shown)
+		}
+	} else {
+		counter, readErr = readCounter(counterFile)
+		if readErr == nil {
+			counter, readErr = applySchedule(counterFile, counter)
+		}
+		shown = strconv.FormatInt(counter, 10)
+	}
 	if readErr != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", readErr)
 		os.Exit(1)
@@ -267,28 +364,58 @@ func main() {
 			os.Exit(1)
 		}
 		if !useYes {
-			_, _ = fmt.Fprintf(os.Stderr, "deleting counter %s (%d) when you re-run with -yes\n", counterName, counter)
+			_, _ = fmt.Fprintf(os.Stderr, "deleting counter %s (%s) when you re-run with -yes\n", counterName, shown)
 			os.Exit(1)
 		}
+		if !meta.typed() && keepsTombstone(counterFile) {
+			if err := writeTombstone(counterFile, counter); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
 		_ = unsetImmutable(counterFile)
 		removeErr := os.Remove(counterFile)
 		if removeErr != nil {
 			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", removeErr)
 		}
+		removeSidecars(counterFile)
 		_, _ = fmt.Fprintf(os.Stdout, "counter %s deleted\n", counterName)
 		os.Exit(1)
 	}
 
+	if meta.typed() {
+		runTypedFlags(counterFile, mainCounterName(), meta, unlock)
+	}
+	if err := requireIntegerFlags(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var update expr
+	if updateExpr != DefaultUpdateExpr {
+		if doAdd || doSub || setToText != "" {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: -expr cannot be combined with -add, -sub or -set\n")
+			os.Exit(1)
+		}
+		parsed, err := parseUpdate(updateExpr)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		update = parsed
+	}
+
 	if doReset && neverReset {
 		_, _ = fmt.Fprintf(os.Stderr, "Error: reset operation is disabled by the environment variable\n")
 		os.Exit(1)
 	}
 
-	if !doReset && !doAdd && !doSub && !doDelete && (setTo == 0 || neverSetTo) {
-		fmt.Println(counter)
+	if !doReset && !doAdd && !doSub && !doDelete && (setTo == 0 || neverSetTo) && update == nil {
+		printValue(mainCounterName(), strconv.FormatInt(counter, 10), meta)
 		os.Exit(0)
 	}
 
+	previous := counter
 	if !doReset && setTo == 0 && doAdd && !neverAdd {
 		if x := counter + quantity; x < math.MaxInt64 {
 			counter = counter + quantity
@@ -315,6 +442,15 @@ func main() {
 		}
 	}
 
+	if !doReset && update != nil {
+		next, err := applyUpdate(update, counter)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		counter = next
+	}
+
 	if doReset {
 		if !useYes {
 			_, _ = fmt.Fprintf(os.Stderr, "will reset counter %s to 0 after you re-run with -yes\n", counterName)
@@ -323,27 +459,25 @@ func main() {
 		counter = 0
 	}
 
-	info, infoErr := os.Stat(counterFile)
-	if infoErr == nil {
-		_ = os.Chmod(counterFile, 0600)
-	}
-
-	file, fileErr := os.OpenFile(counterFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0500)
-	defer file.Close()
-	if fileErr != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", fileErr)
+	output, formatErr := printFormat.apply(mainCounterName(), strconv.FormatInt(counter, 10), meta)
+	if formatErr != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", formatErr)
+		os.Exit(1)
 	}
-	if writeErr := writeCounter(counterFile, counter, file); writeErr != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", writeErr)
+	if storeErr := storeCounter(counterFile, counter); storeErr != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", storeErr)
 		os.Exit(1)
 	}
-
-	if infoErr == nil {
-		_ = os.Chmod(counterFile, info.Mode())
+	if counterName != DefaultCounterName {
+		if err := recordName(counterFile, counterName); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not record counter name: %v\n", err)
+		}
 	}
+	unlock()
+	afterMutation(counterDir, mainCounterName(), counterFile, previous, counter)
 
 	// Output the final counter value
-	fmt.Println(counter)
+	fmt.Println(output)
 }
 
 // readCounter reads the counter value from the specified file.
@@ -356,6 +490,13 @@ func readCounter(filePath string) (int64, error) {
 		return 0, fmt.Errorf("failed to read counter file: %w", err)
 	}
 	counterString := strings.TrimSpace(string(counterBytes))
+	if strings.HasPrefix(counterString, "{") {
+		state, parseErr := parsePN(counterString)
+		if parseErr != nil {
+			return 0, parseErr
+		}
+		return state.value(), nil
+	}
 	counter, parseErr := strconv.ParseInt(counterString, 10, 64)
 	if parseErr != nil {
 		return 0, fmt.Errorf("invalid counter value: %w", parseErr)
@@ -365,7 +506,11 @@ func readCounter(filePath string) (int64, error) {
 
 // writeCounter writes the counter value to the specified file.
 func writeCounter(filePath string, counter int64, file *os.File) error {
-	counterString := strconv.FormatInt(counter, 10)
+	return writeCounterText(filePath, strconv.FormatInt(counter, 10), file)
+}
+
+// writeCounterText writes the text of a counter value to the specified file.
+func writeCounterText(filePath, counterString string, file *os.File) error {
 	bytesWritten, writeErr := file.WriteString(counterString)
 	if writeErr != nil {
 		return fmt.Errorf("writeCounter.go write error: %w", writeErr)
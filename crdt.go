@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultNodeID string = ""
+	BaseNode      string = "_base"
+)
+
+var nodeID string = DefaultNodeID
+
+// pnCounter is the state of a PN-counter: the totals every node has added (P) and subtracted (N), and the
+// generation (G) of every node's totals. Its value is the sum of P minus the sum of N. Two states merge by
+// taking the totals of the later generation of each node, and the larger total within the same generation,
+// so merging is commutative and idempotent and no increment is lost.
+type pnCounter struct {
+	P map[string]int64 `json:"p"`
+	N map[string]int64 `json:"n,omitempty"`
+	G map[string]int64 `json:"g,omitempty"`
+}
+
+// localNode returns the id under which this host records its increments
+func localNode() string {
+	if nodeID != DefaultNodeID {
+		return nodeID
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "localhost"
+	}
+	return host
+}
+
+// parsePN parses the content of a counter file; a plain integer written before counters were
+// PN-counters becomes the total of the shared base node, so copies of it merge to the same value
+func parsePN(text string) (pnCounter, error) {
+	state := pnCounter{P: map[string]int64{}, N: map[string]int64{}, G: map[string]int64{}}
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), &state); err != nil {
+			return state, fmt.Errorf("invalid counter state: %w", err)
+		}
+		if state.P == nil {
+			state.P = map[string]int64{}
+		}
+		if state.N == nil {
+			state.N = map[string]int64{}
+		}
+		if state.G == nil {
+			state.G = map[string]int64{}
+		}
+		return state, nil
+	}
+	if text == "" {
+		return state, nil
+	}
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return state, err
+	}
+	return state, state.adjust(BaseNode, big.NewInt(value))
+}
+
+// total returns the exact value of the counter
+func (c pnCounter) total() *big.Int {
+	sum := new(big.Int)
+	for _, n := range c.P {
+		sum.Add(sum, big.NewInt(n))
+	}
+	for _, n := range c.N {
+		sum.Sub(sum, big.NewInt(n))
+	}
+	return sum
+}
+
+// value returns the value of the counter clamped to the int64 range
+func (c pnCounter) value() int64 {
+	return clampScaled(c.total(), 0)
+}
+
+// adjust records delta as an increment or decrement by node, failing when the node's total would overflow
+func (c *pnCounter) adjust(node string, delta *big.Int) error {
+	totals := c.P
+	if delta.Sign() < 0 {
+		totals = c.N
+		delta = new(big.Int).Neg(delta)
+	}
+	if delta.Sign() == 0 {
+		return nil
+	}
+	sum := new(big.Int).Add(big.NewInt(totals[node]), delta)
+	if !sum.IsInt64() {
+		return fmt.Errorf("the totals of node %s overflow int64", node)
+	}
+	totals[node] = sum.Int64()
+	return nil
+}
+
+// setValue makes the counter hold value by rebasing the totals of node on the value, starting a new
+// generation of them; copies holding an earlier generation of node take the new totals when merged, so
+// the totals stay as small as the value instead of growing with every -set and -reset
+func (c *pnCounter) setValue(node string, value int64) error {
+	others := new(big.Int).Sub(c.total(), big.NewInt(c.P[node]-c.N[node]))
+	own := new(big.Int).Sub(big.NewInt(value), others)
+	if !own.IsInt64() || own.Int64() == math.MinInt64 {
+		return fmt.Errorf("the totals of node %s overflow int64", node)
+	}
+	delete(c.P, node)
+	delete(c.N, node)
+	c.G[node]++
+	return c.adjust(node, own)
+}
+
+// merge folds other into the counter, keeping the later generation of every node and the larger totals within
+// a generation; it reports whether anything changed
+func (c *pnCounter) merge(other pnCounter) bool {
+	nodes := make(map[string]bool)
+	for _, totals := range []map[string]int64{other.P, other.N, other.G} {
+		for node := range totals {
+			nodes[node] = true
+		}
+	}
+	changed := false
+	for node := range nodes {
+		if other.G[node] < c.G[node] {
+			continue
+		}
+		if other.G[node] > c.G[node] {
+			delete(c.P, node)
+			delete(c.N, node)
+			c.G[node] = other.G[node]
+			changed = true
+		}
+		if n := other.P[node]; n > c.P[node] {
+			c.P[node] = n
+			changed = true
+		}
+		if n := other.N[node]; n > c.N[node] {
+			c.N[node] = n
+			changed = true
+		}
+	}
+	return changed
+}
+
+// String encodes the state as the single line of JSON stored in the counter file
+func (c pnCounter) String() string {
+	data, _ := json.Marshal(c)
+	return string(data)
+}
+
+// readPN reads the state of a counter file, returning an empty state when the file does not exist
+func readPN(filePath string) (pnCounter, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return pnCounter{}, fmt.Errorf("failed to read counter file: %w", err)
+	}
+	state, parseErr := parsePN(string(data))
+	if parseErr != nil {
+		return state, fmt.Errorf("invalid counter value: %w", parseErr)
+	}
+	return state, nil
+}
+
+// mergeResult describes what merging a counter from another directory did
+type mergeResult struct {
+	Name     string
+	Old, New int64
+	Changed  bool
+}
+
+// mergeCounter merges the state of a counter from another directory into the local counter file;
+// named counters are also recorded in the local names manifest
+func mergeCounter(filePath, name string, named bool, remote pnCounter, dryRun bool) (mergeResult, error) {
+	result := mergeResult{Name: name}
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return result, lockErr
+	}
+	if err := requireInteger(filePath); err != nil {
+		unlock()
+		return result, err
+	}
+	state, readErr := readPN(filePath)
+	if readErr != nil {
+		unlock()
+		return result, readErr
+	}
+	result.Old = state.value()
+	result.Changed = state.merge(remote)
+	result.New = state.value()
+	if !result.Changed || dryRun {
+		unlock()
+		return result, nil
+	}
+	if err := storeCounterText(filePath, state.String()); err != nil {
+		unlock()
+		return result, err
+	}
+	if named {
+		if err := recordName(filePath, name); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not record counter name: %v\n", err)
+		}
+	}
+	unlock()
+	if result.Old != result.New {
+		afterMutation(filepath.Dir(filePath), name, filePath, result.Old, result.New)
+	}
+	return result, nil
+}
+
+// dirCounter is a counter file found in a counter directory
+type dirCounter struct {
+	File  string
+	Name  string
+	Named bool
+}
+
+// dirCounters lists the counter files in dir along with their names, including deleted counters that left a tombstone
+func dirCounters(dir string) ([]dirCounter, error) {
+	names, namesErr := loadNames(dir)
+	if namesErr != nil {
+		return nil, namesErr
+	}
+	entries, readErr := os.ReadDir(dir)
+	if readErr != nil {
+		return nil, readErr
+	}
+	var counters []dirCounter
+	listed := make(map[string]bool)
+	add := func(file string) {
+		name, ok := nameOf(file, names)
+		if !ok || listed[file] {
+			return
+		}
+		listed[file] = true
+		_, named := names[file]
+		counters = append(counters, dirCounter{File: file, Name: name, Named: named})
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			add(entry.Name())
+		}
+	}
+	// deleted counters leave only their tombstone behind
+	for _, entry := range entries {
+		base := strings.TrimSuffix(entry.Name(), ".tombstone")
+		if base == entry.Name() || !strings.HasPrefix(base, ".") {
+			continue
+		}
+		if _, named := names[base]; named {
+			add(base)
+		} else {
+			add(strings.TrimPrefix(base, "."))
+		}
+	}
+	sort.Slice(counters, func(i, j int) bool { return counters[i].Name < counters[j].Name })
+	return counters, nil
+}
+
+// mergeDir merges every int counter found in dir into the counter file of the same name in into and
+// unions unique counters; other typed counters are skipped because only int counters carry per-node totals
+func mergeDir(dir, into string, dryRun bool) ([]mergeResult, error) {
+	counters, listErr := dirCounters(dir)
+	if listErr != nil {
+		return nil, listErr
+	}
+	var results []mergeResult
+	for _, counter := range counters {
+		remotePath := filepath.Join(dir, counter.File)
+		if meta, err := readMeta(remotePath); err == nil && meta.Type == TypeUnique {
+			result, mergeErr := mergeUniqueFile(remotePath, into, counter, dryRun)
+			if mergeErr != nil {
+				return results, fmt.Errorf("counter %s: %w", counter.Name, mergeErr)
+			}
+			results = append(results, result)
+			continue
+		}
+		if err := requireInteger(remotePath); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: Skipping %s: %v\n", counter.Name, err)
+			continue
+		}
+		remote, remoteErr := readPN(remotePath)
+		if remoteErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: Skipping %s: %v\n", counter.Name, remoteErr)
+			continue
+		}
+		result, mergeErr := mergeCounter(filepath.Join(into, counter.File), counter.Name, counter.Named, remote, dryRun)
+		if mergeErr != nil {
+			return results, fmt.Errorf("counter %s: %w", counter.Name, mergeErr)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// runMerge merges the counters of another counter directory into this one
+func runMerge(args []string) error {
+	var dryRun bool
+	fs := newCommandFlags("merge")
+	fs.BoolVar(&dryRun, "dry-run", false, "show what would change without writing")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: counter merge <other-dir> [-dry-run]")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	from, absErr := filepath.Abs(positional[0])
+	if absErr != nil {
+		return absErr
+	}
+	if to, err := filepath.Abs(counterDir); err == nil && to == from {
+		return errors.New("cannot merge a counter directory into itself")
+	}
+
+	results, mergeErr := mergeDir(from, counterDir, dryRun)
+	for _, result := range results {
+		if result.Changed {
+			fmt.Printf("%s: %d -> %d\n", result.Name, result.Old, result.New)
+		}
+	}
+	return mergeErr
+}
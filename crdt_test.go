@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useNode sets the node id that records increments for the duration of a test
+func useNode(t *testing.T, id string) {
+	t.Helper()
+	previous := nodeID
+	nodeID = id
+	t.Cleanup(func() { nodeID = previous })
+}
+
+// TestParsePN tests that plain integers are upgraded to the base node and JSON states are summed
+func TestParsePN(t *testing.T) {
+	tests := map[string]int64{
+		"":                                0,
+		"42":                              42,
+		"-7":                              -7,
+		`{"p":{"a":5,"b":3},"n":{"a":2}}`: 6,
+	}
+	for text, expected := range tests {
+		state, err := parsePN(text)
+		if err != nil {
+			t.Errorf("parsePN(%q) failed: %v", text, err)
+			continue
+		}
+		if state.value() != expected {
+			t.Errorf("parsePN(%q) = %d, expected %d", text, state.value(), expected)
+		}
+	}
+	if state, _ := parsePN("42"); state.P[BaseNode] != 42 {
+		t.Errorf("Expected a plain value to become the base node total, got %v", state)
+	}
+	if _, err := parsePN("{"); err == nil {
+		t.Errorf("Expected invalid JSON to fail")
+	}
+}
+
+// TestMergePN tests that merging is commutative and idempotent and loses no increments
+func TestMergePN(t *testing.T) {
+	base, _ := parsePN("10")
+	laptop, _ := parsePN(base.String())
+	_ = laptop.setValue("laptop", 13)
+	ci, _ := parsePN(base.String())
+	_ = ci.setValue("ci", 15)
+	_ = ci.setValue("ci", 14)
+
+	left, _ := parsePN(laptop.String())
+	left.merge(ci)
+	right, _ := parsePN(ci.String())
+	right.merge(laptop)
+	if left.value() != 17 || right.value() != 17 {
+		t.Errorf("Expected both merges to give 17, got %d and %d", left.value(), right.value())
+	}
+	if left.merge(ci) || left.merge(laptop) {
+		t.Errorf("Expected merging the same state again to change nothing")
+	}
+	if left.String() != right.String() {
+		t.Errorf("Expected identical states, got %s and %s", left, right)
+	}
+}
+
+// TestSetValueRebases tests that setting a value keeps the totals of the node bounded and wins over copies
+// holding the totals from before the set
+func TestSetValueRebases(t *testing.T) {
+	state, _ := parsePN("")
+	for i := 0; i < 100; i++ {
+		if err := state.setValue("laptop", 1000); err != nil {
+			t.Fatalf("setValue failed: %v", err)
+		}
+		if err := state.setValue("laptop", 0); err != nil {
+			t.Fatalf("setValue failed: %v", err)
+		}
+	}
+	if state.P["laptop"] != 0 || state.N["laptop"] != 0 {
+		t.Errorf("Expected the totals to be rebased on the value, got %s", state)
+	}
+
+	stale, _ := parsePN(`{"p":{"laptop":500,"ci":7}}`)
+	reset, _ := parsePN(stale.String())
+	if err := reset.setValue("laptop", 0); err != nil {
+		t.Fatalf("setValue failed: %v", err)
+	}
+	if reset.value() != 0 || reset.N["laptop"] != 7 {
+		t.Errorf("Expected the reset to offset the other nodes, got %s", reset)
+	}
+	reset.merge(stale)
+	stale.merge(reset)
+	if reset.value() != 0 || stale.value() != 0 {
+		t.Errorf("Expected the reset to survive merging the earlier copy, got %d and %d", reset.value(), stale.value())
+	}
+}
+
+// TestPNOverflow tests that totals beyond the int64 range fail instead of saturating
+func TestPNOverflow(t *testing.T) {
+	state, _ := parsePN(`{"p":{"ci":9223372036854775807}}`)
+	if err := state.setValue("laptop", -1); err == nil {
+		t.Errorf("Expected an overflow error, got %s", state)
+	}
+	if state.value() != math.MaxInt64 || state.G["laptop"] != 0 {
+		t.Errorf("Expected a failed set to leave the state as it was, got %s", state)
+	}
+	if err := state.adjust("ci", big.NewInt(1)); err == nil {
+		t.Errorf("Expected an overflow error, got %s", state)
+	}
+}
+
+// TestMergeDir tests merging the counters of another directory, including a plain legacy counter
+func TestMergeDir(t *testing.T) {
+	dir := useCounterDir(t)
+	useNode(t, "laptop")
+	if _, _, err := updateCounter("jobs", func(current int64) (int64, error) { return current + 3, nil }); err != nil {
+		t.Fatalf("updateCounter failed: %v", err)
+	}
+
+	other := t.TempDir()
+	file := generateCounterFileName("jobs")
+	if err := os.WriteFile(filepath.Join(other, file), []byte(`{"p":{"ci":5},"n":{"ci":1}}`), 0600); err != nil {
+		t.Fatalf("failed to write counter: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(other, NamesFile), []byte(`{"`+file+`":"jobs"}`), 0600); err != nil {
+		t.Fatalf("failed to write names: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(other, "legacy"), []byte("8"), 0600); err != nil {
+		t.Fatalf("failed to write counter: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		results, err := mergeDir(other, dir, false)
+		if err != nil {
+			t.Fatalf("mergeDir failed: %v", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("Expected 2 merged counters, got %v", results)
+		}
+		if results[0].Changed == (i == 1) {
+			t.Errorf("Merge %d: expected changed to be %v, got %v", i+1, i == 0, results[0].Changed)
+		}
+	}
+	if value, _ := readCounter(counterPath("jobs")); value != 7 {
+		t.Errorf("Expected jobs to be 7, got %d", value)
+	}
+	if value, _ := readCounter(filepath.Join(dir, "legacy")); value != 8 {
+		t.Errorf("Expected legacy to be 8, got %d", value)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five field cron expression: minute, hour, day of month, month and day of week
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+// parseCron parses expressions such as "0 0 * * *", "*/15 9-17 * * 1-5" or "0 0 1 1,7 *"
+func parseCron(expr string) (cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("invalid cron expression %q: expected 5 fields", expr)
+	}
+	var c cronSchedule
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return c, fmt.Errorf("invalid cron minute %q: %w", fields[0], err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return c, fmt.Errorf("invalid cron hour %q: %w", fields[1], err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return c, fmt.Errorf("invalid cron day of month %q: %w", fields[2], err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return c, fmt.Errorf("invalid cron month %q: %w", fields[3], err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return c, fmt.Errorf("invalid cron day of week %q: %w", fields[4], err)
+	}
+	// 7 is another name for Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return c, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps into a bit set
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if base, stepText, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, errors.New("invalid step")
+			}
+			part, step = base, n
+		}
+		low, high := min, max
+		if part != "*" {
+			lowText, highText, isRange := strings.Cut(part, "-")
+			var err error
+			if low, err = strconv.Atoi(lowText); err != nil {
+				return 0, errors.New("invalid value")
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highText); err != nil {
+					return 0, errors.New("invalid range")
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("out of range %d-%d", min, max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// matchesDay reports whether t falls on a day selected by the day of month and day of week fields,
+// which like cron select a day when either matches unless one of them is *
+func (c cronSchedule) matchesDay(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// next returns the first time strictly after t that the schedule selects, in t's location
+func (c cronSchedule) next(t time.Time) (time.Time, error) {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("cron expression never fires")
+}
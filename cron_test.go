@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCronNext tests the parseCron and cronSchedule.next functions
+func TestCronNext(t *testing.T) {
+	from := time.Date(2026, 1, 31, 10, 30, 0, 0, time.UTC) // a Saturday
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 1, 31, 10, 31, 0, 0, time.UTC)},
+		{"0 0 * * *", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2026, 2, 2, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 7 *", time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2026, 2, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.expr, err)
+		}
+		got, err := c.next(from)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.expr, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: expected %s, got %s", tt.expr, tt.want, got)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("Expected error for %q", expr)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	TypeDecay       string = "decay"
+	DefaultHalfLife string = "24h"
+	DecaySeparator  string = "@"
+)
+
+// decayValue is the state of a decay counter: its value as of the time it was last updated
+type decayValue struct {
+	Value float64
+	At    time.Time
+}
+
+// parseDecay decodes the content of a decay counter file, stored as value@time; a bare number is a value as of at
+func parseDecay(text string, at time.Time) (decayValue, error) {
+	valueText, atText, stamped := strings.Cut(strings.TrimSpace(text), DecaySeparator)
+	value, err := parseFloat(valueText)
+	if err != nil {
+		return decayValue{}, err
+	}
+	if !stamped {
+		return decayValue{Value: value, At: at}, nil
+	}
+	stamp, stampErr := time.Parse(time.RFC3339Nano, atText)
+	if stampErr != nil {
+		return decayValue{}, fmt.Errorf("invalid decay timestamp %q", atText)
+	}
+	return decayValue{Value: value, At: stamp}, nil
+}
+
+// String encodes the value and the time it was updated as the text stored in the counter file
+func (d decayValue) String() string {
+	return formatFloat(d.Value) + DecaySeparator + d.At.UTC().Format(time.RFC3339Nano)
+}
+
+// decayTo returns the value as of at, halved for every half-life that passed since it was updated; a time
+// before the last update, such as after the clock stepped back, leaves the value as it is
+func (d decayValue) decayTo(at time.Time, halfLife time.Duration) decayValue {
+	elapsed := at.Sub(d.At)
+	if elapsed <= 0 {
+		return d
+	}
+	return decayValue{Value: d.Value * math.Exp2(-elapsed.Seconds()/halfLife.Seconds()), At: at}
+}
+
+// parseHalfLife parses the half-life of a decay counter, which must be a positive duration such as 1h
+func parseHalfLife(text string) (time.Duration, error) {
+	halfLife, err := time.ParseDuration(text)
+	if err != nil || halfLife <= 0 {
+		return 0, fmt.Errorf("invalid half-life %q: expected a positive duration such as 30m or 168h", text)
+	}
+	return halfLife, nil
+}
+
+// halfLife returns the half-life of a decay counter
+func (m counterMeta) halfLife() time.Duration {
+	text := m.HalfLife
+	if text == "" {
+		text = DefaultHalfLife
+	}
+	halfLife, err := parseHalfLife(text)
+	if err != nil {
+		halfLife, _ = parseHalfLife(DefaultHalfLife)
+	}
+	return halfLife
+}
+
+// currentDecay reads the content of a decay counter file as its value at the current time
+func (m counterMeta) currentDecay(text string) (decayValue, error) {
+	at := now()
+	d, err := parseDecay(text, at)
+	if err != nil {
+		return d, err
+	}
+	return d.decayTo(at, m.halfLife()), nil
+}
+
+// addDecay adds operand to a decay counter after decaying it to the current time
+func (m counterMeta) addDecay(current, operand string, negate bool) (string, error) {
+	d, err := m.currentDecay(current)
+	if err != nil {
+		return "", err
+	}
+	amount, amountErr := parseFloat(operand)
+	if amountErr != nil {
+		return "", amountErr
+	}
+	if negate {
+		amount = -amount
+	}
+	if math.IsInf(d.Value+amount, 0) {
+		return "", errors.New("result does not fit a float64")
+	}
+	d.Value += amount
+	return d.String(), nil
+}
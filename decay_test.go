@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestDecayValue tests the parseDecay function and decaying values over whole half-lives
+func TestDecayValue(t *testing.T) {
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	d, err := parseDecay("100", start)
+	if err != nil || d.Value != 100 || !d.At.Equal(start) {
+		t.Fatalf("Expected 100 as of the start, got %+v (%v)", d, err)
+	}
+	if got := d.decayTo(start.Add(2*time.Hour), time.Hour).Value; got != 25 {
+		t.Errorf("Expected 25 after two half-lives, got %v", got)
+	}
+	if got := d.decayTo(start.Add(-time.Hour), time.Hour); got != d {
+		t.Errorf("Expected a time before the update to leave the value, got %+v", got)
+	}
+	parsed, parseErr := parseDecay(d.String(), time.Time{})
+	if parseErr != nil || parsed.Value != 100 || !parsed.At.Equal(start) {
+		t.Errorf("Expected %q to read back, got %+v (%v)", d.String(), parsed, parseErr)
+	}
+	for _, text := range []string{"x@2026-01-01T10:00:00Z", "1@yesterday", "NaN"} {
+		if _, err := parseDecay(text, start); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+	if _, err := parseHalfLife("0s"); err == nil {
+		t.Errorf("Expected a half-life of 0s to be rejected")
+	}
+}
+
+// TestDecayCounter tests that reads decay a counter without writing it and that writes decay it first
+func TestDecayCounter(t *testing.T) {
+	useCounterDir(t)
+	advance := useClock(t, time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	path := counterPath("activity")
+	if _, err := convertType(path, counterMeta{Type: TypeDecay, HalfLife: "1h"}); err != nil {
+		t.Fatalf("failed to convert the counter: %v", err)
+	}
+	if value, err := addTyped("activity", "100"); err != nil || value != "100" {
+		t.Fatalf("Expected 100, got %q (%v)", value, err)
+	}
+	stored, _ := os.ReadFile(path)
+
+	advance(time.Hour)
+	if value, err := readTyped(path); err != nil || value != "50" {
+		t.Errorf("Expected 50 after one half-life, got %q (%v)", value, err)
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(stored) {
+		t.Errorf("Expected reads to leave %q, got %q", stored, after)
+	}
+	if value, err := addTyped("activity", "10"); err != nil || value != "60" {
+		t.Errorf("Expected 60 after adding 10, got %q (%v)", value, err)
+	}
+	advance(time.Hour)
+	if value, _ := readTyped(path); value != "30" {
+		t.Errorf("Expected 30 another half-life later, got %q", value)
+	}
+	meta, _ := readMeta(path)
+	if got := meta.typeName(); got != "decay (half-life 1h)" {
+		t.Errorf("Expected decay (half-life 1h), got %q", got)
+	}
+	if value, err := convertType(path, counterMeta{Type: TypeFloat}); err != nil || value != "30" {
+		t.Errorf("Expected the conversion to keep 30, got %q (%v)", value, err)
+	}
+}
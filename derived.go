@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// derivedExpr parses the expression of the counter stored in filePath, reporting ok as false for
+// counters that are not derived
+func derivedExpr(filePath string) (e expr, ok bool, err error) {
+	meta, metaErr := readMeta(filePath)
+	if metaErr != nil || meta.Type != TypeDerived {
+		return nil, false, metaErr
+	}
+	e, err = parseExpr(meta.Expr)
+	return e, true, err
+}
+
+// findCycle reports an error when defining name as e would make a counter depend on itself
+func findCycle(name string, e expr) error {
+	visited := make(map[string]bool)
+	var visit func(e expr, trail []string) error
+	visit = func(e expr, trail []string) error {
+		for _, ref := range exprRefs(e) {
+			path := append(append([]string(nil), trail...), ref)
+			if ref == name {
+				return fmt.Errorf("cycle: %s", strings.Join(path, " -> "))
+			}
+			if visited[ref] {
+				continue
+			}
+			visited[ref] = true
+			definition, derived, err := derivedExpr(counterPath(ref))
+			if err != nil {
+				return fmt.Errorf("counter %s: %w", ref, err)
+			}
+			if derived {
+				if err := visit(definition, path); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return visit(e, []string{name})
+}
+
+// evalDerived evaluates the expression of a derived counter against a consistent snapshot: the
+// counters it depends on, directly or through other derived counters, are locked while they are read
+func evalDerived(text string) (int64, error) {
+	root, parseErr := parseExpr(text)
+	if parseErr != nil {
+		return 0, parseErr
+	}
+	defs := make(map[string]expr)
+	bases := make(map[string]string)
+	pending := exprRefs(root)
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if _, ok := defs[name]; ok {
+			continue
+		}
+		if _, ok := bases[name]; ok {
+			continue
+		}
+		filePath := counterPath(name)
+		e, derived, err := derivedExpr(filePath)
+		if err != nil {
+			return 0, fmt.Errorf("counter %s: %w", name, err)
+		}
+		if derived {
+			defs[name] = e
+			pending = append(pending, exprRefs(e)...)
+		} else {
+			bases[name] = filePath
+		}
+	}
+
+	// lock in a fixed order so that concurrent readers cannot deadlock
+	names := make([]string, 0, len(bases))
+	for name := range bases {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return bases[names[i]] < bases[names[j]] })
+	values := make(map[string]int64, len(bases))
+	for _, name := range names {
+		unlock, lockErr := lockFile(lockPath(bases[name]))
+		if lockErr != nil {
+			return 0, lockErr
+		}
+		defer unlock()
+		value, readErr := snapshotValue(bases[name])
+		if readErr != nil {
+			return 0, fmt.Errorf("counter %s: %w", name, readErr)
+		}
+		values[name] = value
+	}
+
+	evaluating := make(map[string]bool)
+	var lookup func(name string) (int64, error)
+	lookup = func(name string) (int64, error) {
+		e, derived := defs[name]
+		if !derived {
+			return values[name], nil
+		}
+		if evaluating[name] {
+			return 0, fmt.Errorf("counter %s depends on itself", name)
+		}
+		evaluating[name] = true
+		defer delete(evaluating, name)
+		return e.eval(lookup)
+	}
+	return root.eval(lookup)
+}
+
+// snapshotValue reads a counter whose lock is held as the integer that expressions see
+func snapshotValue(filePath string) (int64, error) {
+	meta, metaErr := readMeta(filePath)
+	if metaErr != nil {
+		return 0, metaErr
+	}
+	if meta.typed() {
+		stored, err := readStored(filePath, meta)
+		return meta.wholeValue(stored), err
+	}
+	value, err := readCounter(filePath)
+	if err != nil {
+		return value, err
+	}
+	return applySchedule(filePath, value)
+}
+
+// requireWritable fails for derived counters, whose value can only be read
+func requireWritable(meta counterMeta) error {
+	if meta.Type == TypeDerived {
+		return fmt.Errorf("counter is derived from %s and cannot be changed", meta.Expr)
+	}
+	return nil
+}
+
+// defineDerived makes the named counter derived from an expression; counters that already hold a value
+// must be deleted first, so that no count is silently discarded
+func defineDerived(name, text string) (string, error) {
+	e, parseErr := parseExpr(text)
+	if parseErr != nil {
+		return "", parseErr
+	}
+	if err := findCycle(name, e); err != nil {
+		return "", err
+	}
+	filePath := counterPath(name)
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return "", lockErr
+	}
+	defer unlock()
+	meta, metaErr := readMeta(filePath)
+	if metaErr != nil {
+		return "", metaErr
+	}
+	if _, err := os.Stat(filePath); err == nil && meta.Type != TypeDerived {
+		return "", errors.New("counter already holds a value; delete it before deriving it")
+	}
+	if meta.Schedule != nil {
+		return "", errors.New("counters with a reset schedule cannot be derived")
+	}
+	meta.Type, meta.Scale, meta.Precision, meta.Expr = TypeDerived, 0, nil, text
+	if err := writeMeta(filePath, meta); err != nil {
+		return "", err
+	}
+	if err := storeCounterText(filePath, "0"); err != nil {
+		return "", err
+	}
+	if err := recordName(filePath, name); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not record counter name: %v\n", err)
+	}
+	value, evalErr := evalDerived(text)
+	return strconv.FormatInt(value, 10), evalErr
+}
+
+// runDerive defines a counter computed from other counters, or shows the expression of one
+func runDerive(args []string) error {
+	fs := newCommandFlags("derive")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		return errors.New("usage: counter derive <name> ['<expression>']")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	name := positional[0]
+	if len(positional) == 1 {
+		meta, err := readMeta(counterPath(name))
+		if err != nil {
+			return err
+		}
+		if meta.Type != TypeDerived {
+			return fmt.Errorf("counter %s is not derived", name)
+		}
+		fmt.Println(meta.Expr)
+		return nil
+	}
+	value, defineErr := defineDerived(name, positional[1])
+	if defineErr != nil {
+		return fmt.Errorf("counter %s: %w", name, defineErr)
+	}
+	fmt.Printf("counter %s = %s: %s\n", name, positional[1], value)
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDerivedCounter tests defining derived counters and reading them like other counters
+func TestDerivedCounter(t *testing.T) {
+	useCounterDir(t)
+	setCounters(t, map[string]int64{"errors": 3, "requests": 1000})
+	if value, err := defineDerived("error_rate", "errors * 1000 / requests"); err != nil || value != "3" {
+		t.Fatalf("Expected error_rate to be 3, got %q (%v)", value, err)
+	}
+	if value, err := defineDerived("errors_total", "error_rate + errors"); err != nil || value != "6" {
+		t.Fatalf("Expected errors_total to be 6, got %q (%v)", value, err)
+	}
+	setCounters(t, map[string]int64{"errors": 10})
+	if value, err := readTyped(counterPath("errors_total")); err != nil || value != "20" {
+		t.Errorf("Expected errors_total to be 20, got %q (%v)", value, err)
+	}
+	if _, err := addTyped("error_rate", "1"); err == nil {
+		t.Errorf("Expected adding to a derived counter to fail")
+	}
+	if _, _, err := updateCounter("error_rate", func(int64) (int64, error) { return 1, nil }); err == nil {
+		t.Errorf("Expected updating a derived counter to fail")
+	}
+	if _, err := defineDerived("errors", "requests"); err == nil {
+		t.Errorf("Expected deriving a counter that holds a value to fail")
+	}
+}
+
+// TestDerivedCycle tests that definitions which make a counter depend on itself are refused
+func TestDerivedCycle(t *testing.T) {
+	useCounterDir(t)
+	if _, err := defineDerived("a", "b + 1"); err != nil {
+		t.Fatalf("defineDerived failed: %v", err)
+	}
+	if _, err := defineDerived("b", "c * 2"); err != nil {
+		t.Fatalf("defineDerived failed: %v", err)
+	}
+	_, err := defineDerived("c", "a - 1")
+	if err == nil || !strings.Contains(err.Error(), "c -> a -> b -> c") {
+		t.Errorf("Expected a cycle through a and b, got %v", err)
+	}
+	if _, err := defineDerived("d", "d + 1"); err == nil {
+		t.Errorf("Expected a counter derived from itself to be refused")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	DefaultExportFormat string = "json"
+	PromMetricName      string = "counter_value"
+	PromHistogramName   string = "counter_histogram"
+)
+
+// exportedCounter is a counter as it appears in JSON listings and exports
+type exportedCounter struct {
+	Name      string             `json:"name"`
+	Type      string             `json:"type"`
+	Value     json.Number        `json:"value"`
+	Labels    map[string]string  `json:"labels,omitempty"`
+	Unit      string             `json:"unit,omitempty"`
+	Histogram *exportedHistogram `json:"histogram,omitempty"`
+}
+
+// exportedHistogram is the distribution of a histogram counter, with the cumulative count of every bucket
+type exportedHistogram struct {
+	Count   uint64           `json:"count"`
+	Sum     float64          `json:"sum"`
+	Min     float64          `json:"min"`
+	Max     float64          `json:"max"`
+	Buckets []exportedBucket `json:"buckets"`
+}
+
+// exportedBucket is the number of observations less than or equal to a bound
+type exportedBucket struct {
+	LE    string `json:"le"`
+	Count uint64 `json:"count"`
+}
+
+// exportHistogram reads the histogram of an entry with the cumulative counts that exports use
+func exportHistogram(entry indexEntry) (*exportedHistogram, error) {
+	h, err := readHistogram(entry.Path, entry.Meta)
+	if err != nil {
+		return nil, fmt.Errorf("counter %s: %w", entry.Name, err)
+	}
+	bounds := entry.Meta.histogramBuckets()
+	exported := &exportedHistogram{Count: h.Count, Sum: h.Sum, Min: h.Min, Max: h.Max}
+	var cumulative uint64
+	for i, n := range h.Counts {
+		cumulative += n
+		le := "+Inf"
+		if i < len(bounds) {
+			le = formatFloat(bounds[i])
+		}
+		exported.Buckets = append(exported.Buckets, exportedBucket{LE: le, Count: cumulative})
+	}
+	return exported, nil
+}
+
+// exportCounter converts an entry of the name index for JSON output
+func exportCounter(entry indexEntry) (exportedCounter, error) {
+	kind := entry.Meta.Type
+	if kind == "" {
+		kind = TypeInt
+	}
+	exported := exportedCounter{Name: entry.Name, Type: kind, Value: json.Number(entry.Value), Labels: entry.Meta.Labels, Unit: entry.Meta.Unit}
+	if kind == TypeHistogram {
+		h, err := exportHistogram(entry)
+		if err != nil {
+			return exported, err
+		}
+		exported.Histogram = h
+	}
+	return exported, nil
+}
+
+// promEscape escapes a label value for the Prometheus text format
+func promEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// promLabels formats the counter name and labels of an entry as Prometheus labels, followed by extra labels
+func promLabels(entry indexEntry, extra ...string) string {
+	labels := []string{`name="` + promEscape(entry.Name) + `"`}
+	for _, pair := range strings.Split(formatLabels(entry.Meta.Labels), ",") {
+		// labels stored before their key was reserved would clash with the labels of the export
+		if key, value, ok := strings.Cut(pair, "="); ok && !reservedLabels[key] {
+			labels = append(labels, key+`="`+promEscape(value)+`"`)
+		}
+	}
+	return "{" + strings.Join(append(labels, extra...), ",") + "}"
+}
+
+// writeProm writes counters in the Prometheus text exposition format, with the counter name and labels as
+// labels; histogram counters form a histogram family with _bucket, _sum and _count series
+func writeProm(w io.Writer, entries []indexEntry) error {
+	var gauges, histograms []indexEntry
+	for _, entry := range entries {
+		if entry.Meta.Type == TypeHistogram {
+			histograms = append(histograms, entry)
+		} else {
+			gauges = append(gauges, entry)
+		}
+	}
+	if len(gauges) > 0 || len(histograms) == 0 {
+		if _, err := fmt.Fprintf(w, "# HELP %s Value of a counter.\n# TYPE %s gauge\n", PromMetricName, PromMetricName); err != nil {
+			return err
+		}
+	}
+	for _, entry := range gauges {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", PromMetricName, promLabels(entry), entry.Value); err != nil {
+			return err
+		}
+	}
+	if len(histograms) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "# HELP %s Observations of a histogram counter.\n# TYPE %s histogram\n", PromHistogramName, PromHistogramName); err != nil {
+		return err
+	}
+	for _, entry := range histograms {
+		h, err := exportHistogram(entry)
+		if err != nil {
+			return err
+		}
+		for _, bucket := range h.Buckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", PromHistogramName, promLabels(entry, `le="`+bucket.LE+`"`), bucket.Count); err != nil {
+				return err
+			}
+		}
+		labels := promLabels(entry)
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", PromHistogramName, labels, formatFloat(h.Sum), PromHistogramName, labels, h.Count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runList prints the counters whose name matches a pattern and whose labels match a selector
+func runList(args []string) error {
+	var (
+		selector string
+		asJSON   bool
+		format   = DefaultListFormat
+	)
+	fs := newCommandFlags("list")
+	fs.StringVar(&selector, "l", "", "label selector such as env=prod,owner!=infra")
+	fs.BoolVar(&asJSON, "json", false, "print the counters as newline delimited JSON")
+	fs.StringVar(&format, "format", format, "print values as auto, raw, comma, si, iec, hex, pad:N or a template")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) > 1 {
+		return errors.New("usage: counter list [pattern] [-l selector] [-json] [-format F]")
+	}
+	listFormat, formatErr := parseValueFormat(format)
+	if formatErr != nil {
+		return formatErr
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	pattern := "*"
+	if len(positional) == 1 {
+		pattern = positional[0]
+	}
+	entries, selectErr := selectCounters(pattern, selector)
+	if selectErr != nil {
+		return selectErr
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	for _, entry := range entries {
+		if asJSON {
+			exported, err := exportCounter(entry)
+			if err != nil {
+				return err
+			}
+			_ = encoder.Encode(exported)
+			continue
+		}
+		value, err := listFormat.apply(entry.Name, entry.Value, entry.Meta)
+		if err != nil {
+			return fmt.Errorf("counter %s: %w", entry.Name, err)
+		}
+		if listFormat.Kind == "template" {
+			fmt.Println(value)
+		} else {
+			fmt.Printf("%s\t%s\t%s\n", entry.Name, value, formatLabels(entry.Meta.Labels))
+		}
+	}
+	return nil
+}
+
+// runExport writes the counters whose name matches a pattern and whose labels match a selector as JSON or
+// in the Prometheus text format
+func runExport(args []string) error {
+	var (
+		selector string
+		format   = DefaultExportFormat
+	)
+	fs := newCommandFlags("export")
+	fs.StringVar(&selector, "l", "", "label selector such as env=prod,owner!=infra")
+	fs.StringVar(&format, "format", format, "json or prom")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) > 1 {
+		return errors.New("usage: counter export [pattern] [-l selector] [-format json|prom]")
+	}
+	if format != "json" && format != "prom" {
+		return fmt.Errorf("invalid format %q: expected json or prom", format)
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	pattern := "*"
+	if len(positional) == 1 {
+		pattern = positional[0]
+	}
+	entries, selectErr := selectCounters(pattern, selector)
+	if selectErr != nil {
+		return selectErr
+	}
+	if format == "prom" {
+		return writeProm(os.Stdout, entries)
+	}
+	counters := make([]exportedCounter, len(entries))
+	for i, entry := range entries {
+		exported, err := exportCounter(entry)
+		if err != nil {
+			return err
+		}
+		counters[i] = exported
+	}
+	data, marshalErr := json.MarshalIndent(counters, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// TestWriteProm tests the Prometheus text format of exported counters
+func TestWriteProm(t *testing.T) {
+	entries := []indexEntry{
+		{Name: "api.hits", Value: "42", Meta: counterMeta{Labels: map[string]string{"owner": "payments", "env": `pr"od`}}},
+		{Name: "cash", Value: "10.25", Meta: counterMeta{Type: TypeDecimal, Scale: 2, Labels: map[string]string{"le": "foo"}}},
+	}
+	var out bytes.Buffer
+	if err := writeProm(&out, entries); err != nil {
+		t.Fatalf("writeProm failed: %v", err)
+	}
+	want := "# HELP counter_value Value of a counter.\n# TYPE counter_value gauge\n" +
+		"counter_value{name=\"api.hits\",env=\"pr\\\"od\",owner=\"payments\"} 42\n" +
+		"counter_value{name=\"cash\"} 10.25\n"
+	if out.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, out.String())
+	}
+	if err := validLabel("le", "foo"); err == nil {
+		t.Errorf("Expected le to be reserved for the bounds of histogram buckets")
+	}
+}
+
+// TestExportCounter tests the JSON form of exported counters
+func TestExportCounter(t *testing.T) {
+	entry := indexEntry{Name: "api.hits", Value: "42", Meta: counterMeta{Labels: map[string]string{"env": "prod"}}}
+	exported, exportErr := exportCounter(entry)
+	if exportErr != nil {
+		t.Fatalf("exportCounter failed: %v", exportErr)
+	}
+	data, err := json.Marshal(exported)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := `{"name":"api.hits","type":"int","value":42,"labels":{"env":"prod"}}`
+	if string(data) != want {
+		t.Errorf("Expected %s, got %s", want, data)
+	}
+}
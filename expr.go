@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// errOverflow is returned when integer arithmetic in an expression leaves the int64 range
+var errOverflow = errors.New("integer overflow")
+
+// expr is a parsed integer expression
+type expr interface {
+	eval(lookup func(name string) (int64, error)) (int64, error)
+}
+
+// numberExpr is an integer literal
+type numberExpr int64
+
+// refExpr is a reference to a counter, or to a variable such as x
+type refExpr string
+
+// negExpr negates its operand
+type negExpr struct {
+	operand expr
+}
+
+// binaryExpr applies one of + - * / % to two operands
+type binaryExpr struct {
+	op          byte
+	left, right expr
+}
+
+// callExpr calls one of the functions min, max and abs
+type callExpr struct {
+	fn   string
+	args []expr
+}
+
+// eval returns the literal
+func (e numberExpr) eval(func(string) (int64, error)) (int64, error) {
+	return int64(e), nil
+}
+
+// eval looks up the value of the reference
+func (e refExpr) eval(lookup func(string) (int64, error)) (int64, error) {
+	return lookup(string(e))
+}
+
+// eval negates the operand, failing for the most negative int64
+func (e negExpr) eval(lookup func(string) (int64, error)) (int64, error) {
+	value, err := e.operand.eval(lookup)
+	if err != nil {
+		return 0, err
+	}
+	if value == math.MinInt64 {
+		return 0, errOverflow
+	}
+	return -value, nil
+}
+
+// eval applies the operator with checked arithmetic; division and remainder by zero are 0
+func (e binaryExpr) eval(lookup func(string) (int64, error)) (int64, error) {
+	a, aErr := e.left.eval(lookup)
+	if aErr != nil {
+		return 0, aErr
+	}
+	b, bErr := e.right.eval(lookup)
+	if bErr != nil {
+		return 0, bErr
+	}
+	switch e.op {
+	case '+':
+		if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+			return 0, errOverflow
+		}
+		return a + b, nil
+	case '-':
+		if (b < 0 && a > math.MaxInt64+b) || (b > 0 && a < math.MinInt64+b) {
+			return 0, errOverflow
+		}
+		return a - b, nil
+	case '*':
+		if a == 0 || b == 0 {
+			return 0, nil
+		}
+		product := a * b
+		if product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+			return 0, errOverflow
+		}
+		return product, nil
+	case '/':
+		if b == 0 {
+			return 0, nil
+		}
+		if a == math.MinInt64 && b == -1 {
+			return 0, errOverflow
+		}
+		return a / b, nil
+	case '%':
+		if b == 0 {
+			return 0, nil
+		}
+		return a % b, nil
+	}
+	return 0, fmt.Errorf("unknown operator %q", e.op)
+}
+
+// eval calls the function
+func (e callExpr) eval(lookup func(string) (int64, error)) (int64, error) {
+	values := make([]int64, len(e.args))
+	for i, arg := range e.args {
+		value, err := arg.eval(lookup)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = value
+	}
+	switch e.fn {
+	case "min":
+		return slices.Min(values), nil
+	case "max":
+		return slices.Max(values), nil
+	case "abs":
+		if values[0] == math.MinInt64 {
+			return 0, errOverflow
+		}
+		if values[0] < 0 {
+			return -values[0], nil
+		}
+		return values[0], nil
+	}
+	return 0, fmt.Errorf("unknown function %s", e.fn)
+}
+
+// exprFunctions maps the functions an expression may call to the number of arguments they take,
+// where -1 means one or more
+var exprFunctions = map[string]int{"min": -1, "max": -1, "abs": 1}
+
+// exprRefs returns the references in an expression in the order they first appear
+func exprRefs(e expr) []string {
+	var names []string
+	seen := make(map[string]bool)
+	var walk func(expr)
+	walk = func(e expr) {
+		switch node := e.(type) {
+		case refExpr:
+			if !seen[string(node)] {
+				seen[string(node)] = true
+				names = append(names, string(node))
+			}
+		case negExpr:
+			walk(node.operand)
+		case binaryExpr:
+			walk(node.left)
+			walk(node.right)
+		case callExpr:
+			for _, arg := range node.args {
+				walk(arg)
+			}
+		}
+	}
+	walk(e)
+	return names
+}
+
+// exprParser is a recursive descent parser over the text of an expression
+type exprParser struct {
+	text string
+	pos  int
+}
+
+// parseExpr parses an integer expression made of literals, references, the operators + - * / %,
+// parentheses and calls to min, max and abs; references are names made of letters, digits, '_' and
+// '.', or any name in double quotes
+func parseExpr(text string) (expr, error) {
+	p := &exprParser{text: text}
+	e, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.skip(); p.pos < len(p.text) {
+		return nil, p.errorf("unexpected %q", p.text[p.pos])
+	}
+	return e, nil
+}
+
+// errorf reports a syntax error at the current position
+func (p *exprParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("invalid expression %q at offset %d: %s", p.text, p.pos, fmt.Sprintf(format, args...))
+}
+
+// skip moves past white space
+func (p *exprParser) skip() {
+	for p.pos < len(p.text) && unicode.IsSpace(rune(p.text[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next character after white space, or 0 at the end of the text
+func (p *exprParser) peek() byte {
+	p.skip()
+	if p.pos < len(p.text) {
+		return p.text[p.pos]
+	}
+	return 0
+}
+
+// sum parses terms joined by + and -
+func (p *exprParser) sum() (expr, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, rightErr := p.product()
+		if rightErr != nil {
+			return nil, rightErr
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// product parses unary expressions joined by *, / and %
+func (p *exprParser) product() (expr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/' || op == '%'; op = p.peek() {
+		p.pos++
+		right, rightErr := p.unary()
+		if rightErr != nil {
+			return nil, rightErr
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// unary parses an optionally negated operand
+func (p *exprParser) unary() (expr, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return negExpr{operand}, nil
+	case '+':
+		p.pos++
+		return p.unary()
+	}
+	return p.operand()
+}
+
+// isNameChar reports whether c may appear in an unquoted reference
+func isNameChar(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// operand parses a literal, a reference, a call or a parenthesized expression
+func (p *exprParser) operand() (expr, error) {
+	c := p.peek()
+	start := p.pos
+	switch {
+	case c == 0:
+		return nil, p.errorf("unexpected end")
+	case c == '(':
+		p.pos++
+		e, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return e, nil
+	case c == '"':
+		end := strings.IndexByte(p.text[start+1:], '"')
+		if end < 1 {
+			return nil, p.errorf("unterminated or empty quoted name")
+		}
+		p.pos = start + end + 2
+		return refExpr(p.text[start+1 : start+1+end]), nil
+	case c >= '0' && c <= '9':
+		for p.pos < len(p.text) && p.text[p.pos] >= '0' && p.text[p.pos] <= '9' {
+			p.pos++
+		}
+		value, err := strconv.ParseInt(p.text[start:p.pos], 10, 64)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("%s does not fit an int64", p.text[start:])
+		}
+		return numberExpr(value), nil
+	case isNameChar(c):
+		for p.pos < len(p.text) && isNameChar(p.text[p.pos]) {
+			p.pos++
+		}
+		name := p.text[start:p.pos]
+		if p.peek() != '(' {
+			return refExpr(name), nil
+		}
+		return p.call(name)
+	}
+	return nil, p.errorf("unexpected %q", c)
+}
+
+// call parses the arguments of a call to the function fn
+func (p *exprParser) call(fn string) (expr, error) {
+	arity, ok := exprFunctions[fn]
+	if !ok {
+		return nil, p.errorf("unknown function %s", fn)
+	}
+	p.pos++
+	var args []expr
+	for p.peek() != ')' {
+		if len(args) > 0 {
+			if p.peek() != ',' {
+				return nil, p.errorf("expected , or )")
+			}
+			p.pos++
+		}
+		arg, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.pos++
+	if len(args) == 0 || (arity > 0 && len(args) != arity) {
+		return nil, p.errorf("wrong number of arguments to %s", fn)
+	}
+	return callExpr{fn: fn, args: args}, nil
+}
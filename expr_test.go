@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// TestParseExpr tests parsing and evaluating expressions with references
+func TestParseExpr(t *testing.T) {
+	values := map[string]int64{"errors": 3, "requests": 1000, "team.api.hits": 7, "a-b": 2, "x": 9}
+	lookup := func(name string) (int64, error) { return values[name], nil }
+	tests := []struct {
+		text string
+		want int64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"errors * 1000 / requests", 3},
+		{"team.api.hits - -3", 10},
+		{`"a-b" + 1`, 3},
+		{"7 / 0", 0},
+		{"7 % 0", 0},
+		{"x % 7", 2},
+		{"max(x, 500)", 500},
+		{"min(x, 500, -1)", -1},
+		{"abs(2 - x)", 7},
+		{"-x * 2", -18},
+		{"10 - 2 - 3", 5},
+	}
+	for _, tt := range tests {
+		e, err := parseExpr(tt.text)
+		if err != nil {
+			t.Fatalf("parseExpr(%q) failed: %v", tt.text, err)
+		}
+		if got, err := e.eval(lookup); err != nil || got != tt.want {
+			t.Errorf("Expected %q to be %d, got %d (%v)", tt.text, tt.want, got, err)
+		}
+	}
+	for _, text := range []string{"", "1 +", "(1", "foo(1)", "max()", "abs(1, 2)", "1 2", `"x`, "99999999999999999999"} {
+		if _, err := parseExpr(text); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+}
+
+// TestExprOverflow tests that arithmetic leaving the int64 range fails instead of wrapping
+func TestExprOverflow(t *testing.T) {
+	values := map[string]int64{"big": math.MaxInt64, "small": math.MinInt64}
+	lookup := func(name string) (int64, error) { return values[name], nil }
+	for _, text := range []string{"big + 1", "small - 1", "big * 2", "small / -1", "-small", "abs(small)", "small * -1"} {
+		e, err := parseExpr(text)
+		if err != nil {
+			t.Fatalf("parseExpr(%q) failed: %v", text, err)
+		}
+		if _, err := e.eval(lookup); !errors.Is(err, errOverflow) {
+			t.Errorf("Expected %q to overflow, got %v", text, err)
+		}
+	}
+	e, _ := parseExpr("big - 1 + 1")
+	if got, err := e.eval(lookup); err != nil || got != math.MaxInt64 {
+		t.Errorf("Expected %d, got %d (%v)", int64(math.MaxInt64), got, err)
+	}
+}
+
+// TestExprRefs tests the exprRefs function
+func TestExprRefs(t *testing.T) {
+	e, err := parseExpr("a + max(b, a) * -c")
+	if err != nil {
+		t.Fatalf("parseExpr failed: %v", err)
+	}
+	if got := exprRefs(e); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("Expected [a b c], got %v", got)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	DefaultOutputFormat string = "raw"
+	DefaultListFormat   string = "auto"
+	UnitBytes           string = "bytes"
+	UnitNone            string = "none"
+	MaxUnitLen          int    = 16
+	MaxPadWidth         int    = 64
+)
+
+var (
+	outputFormat = DefaultOutputFormat
+	printFormat  = valueFormat{Kind: DefaultOutputFormat}
+)
+
+// siPrefixes and iecPrefixes are the prefixes of every power of 1000 and 1024
+var (
+	siPrefixes  = []string{"", "k", "M", "G", "T", "P", "E"}
+	iecPrefixes = []string{"", "Ki", "Mi", "Gi", "Ti", "Pi", "Ei"}
+)
+
+// valueFormat is a way of printing counter values: raw, auto, comma, si, iec, hex, pad:N or a template
+type valueFormat struct {
+	Kind     string
+	Width    int
+	Template *template.Template
+}
+
+// formattedValue is what a -format template sees, such as {{.Name}}={{.Value}}
+type formattedValue struct {
+	Name  string
+	Value string
+	Unit  string
+	Type  string
+}
+
+// parseValueFormat parses a -format option
+func parseValueFormat(text string) (valueFormat, error) {
+	if strings.Contains(text, "{{") {
+		tmpl, err := template.New("format").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return valueFormat{}, fmt.Errorf("invalid format template: %w", err)
+		}
+		// fields that do not exist are caught before the counter is changed rather than when it is printed
+		if err := tmpl.Execute(io.Discard, formattedValue{}); err != nil {
+			return valueFormat{}, fmt.Errorf("invalid format template: %w", err)
+		}
+		return valueFormat{Kind: "template", Template: tmpl}, nil
+	}
+	switch text {
+	case "raw", "auto", "comma", "si", "iec", "hex":
+		return valueFormat{Kind: text}, nil
+	}
+	if widthText, ok := strings.CutPrefix(text, "pad:"); ok {
+		width, err := strconv.Atoi(widthText)
+		if err != nil || width < 1 || width > MaxPadWidth {
+			return valueFormat{}, fmt.Errorf("invalid padding %q: expected a width from 1 to %d", widthText, MaxPadWidth)
+		}
+		return valueFormat{Kind: "pad", Width: width}, nil
+	}
+	return valueFormat{}, fmt.Errorf("invalid format %q: expected raw, auto, comma, si, iec, hex, pad:N or a template such as '{{.Name}}={{.Value}}'", text)
+}
+
+// apply formats the value of a counter; si and iec append the unit of the counter, and auto picks iec for
+// byte counters, si for counters with another unit and raw for the rest
+func (f valueFormat) apply(name, value string, meta counterMeta) (string, error) {
+	kind := f.Kind
+	if kind == "auto" {
+		switch meta.Unit {
+		case "":
+			kind = "raw"
+		case UnitBytes:
+			kind = "iec"
+		default:
+			kind = "si"
+		}
+	}
+	switch kind {
+	case "comma":
+		return groupThousands(value), nil
+	case "si":
+		return scaleValue(value, 1000, siPrefixes, meta.Unit)
+	case "iec":
+		return scaleValue(value, 1024, iecPrefixes, meta.Unit)
+	case "hex":
+		r, err := parseNumber(value)
+		if err != nil || !r.IsInt() {
+			return "", fmt.Errorf("hex needs an integer, not %s", value)
+		}
+		if r.Sign() < 0 {
+			return "-0x" + new(big.Int).Neg(r.Num()).Text(16), nil
+		}
+		return "0x" + r.Num().Text(16), nil
+	case "pad":
+		digits, negative := strings.CutPrefix(value, "-")
+		if negative {
+			return "-" + zeroPad(digits, f.Width-1), nil
+		}
+		return zeroPad(digits, f.Width), nil
+	case "template":
+		var b strings.Builder
+		data := formattedValue{Name: name, Value: value, Unit: meta.Unit, Type: meta.typeName()}
+		if err := f.Template.Execute(&b, data); err != nil {
+			return "", fmt.Errorf("format template: %w", err)
+		}
+		return b.String(), nil
+	}
+	return value, nil
+}
+
+// zeroPad pads text with leading zeros to width characters
+func zeroPad(text string, width int) string {
+	if len(text) >= width {
+		return text
+	}
+	return strings.Repeat("0", width-len(text)) + text
+}
+
+// groupThousands separates the thousands of the integer part of a number with commas, such as 1,234,567.5
+func groupThousands(value string) string {
+	digits, negative := strings.CutPrefix(value, "-")
+	whole, fraction, decimal := strings.Cut(digits, ".")
+	if strings.ContainsAny(whole, "eE") {
+		return value
+	}
+	var b strings.Builder
+	if negative {
+		b.WriteString("-")
+	}
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(",")
+		}
+		b.WriteRune(digit)
+	}
+	if decimal {
+		b.WriteString("." + fraction)
+	}
+	return b.String()
+}
+
+// scaleValue divides a value by powers of base until it is below base and prints it with one decimal place
+// and the matching prefix, such as 1.2M or 3.4GiB; values below base are printed as they are
+func scaleValue(value string, base float64, prefixes []string, unit string) (string, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("invalid number %q", value)
+	}
+	i := 0
+	for i < len(prefixes)-1 && math.Abs(f) >= base {
+		f /= base
+		i++
+	}
+	// a value that rounds up to base, such as 999.96k, moves on to the next prefix
+	if i > 0 && i < len(prefixes)-1 && math.Abs(math.Round(f*10)/10) >= base {
+		f /= base
+		i++
+	}
+	text, prefix := value, prefixes[i]
+	if i > 0 {
+		text = strings.TrimSuffix(strconv.FormatFloat(f, 'f', 1, 64), ".0")
+	}
+	switch unit {
+	case "":
+		return text + prefix, nil
+	case UnitBytes:
+		return text + prefix + "B", nil
+	}
+	return text + prefix + " " + unit, nil
+}
+
+// printValue prints the value of a counter in the -format given on the command line and exits on failure
+func printValue(name, value string, meta counterMeta) {
+	text, err := printFormat.apply(name, value, meta)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(text)
+}
+
+// mainCounterName returns the name of the counter given with -name; a counter given with -file is named by
+// its path relative to the counter directory, or by its absolute path when it lies outside of it
+func mainCounterName() string {
+	if counterName != DefaultCounterName {
+		return counterName
+	}
+	if rel, err := filepath.Rel(counterDir, counterFile); err == nil && filepath.IsLocal(rel) {
+		return rel
+	}
+	if abs, err := filepath.Abs(counterFile); err == nil {
+		return abs
+	}
+	return counterFile
+}
+
+// validUnit reports an error for units that would not print on a single line next to a value
+func validUnit(unit string) error {
+	if unit == "" || len(unit) > MaxUnitLen || strings.ContainsAny(unit, " \t\r\n") {
+		return fmt.Errorf("invalid unit %q: expected up to %d characters without spaces, such as bytes or requests", unit, MaxUnitLen)
+	}
+	return nil
+}
+
+// runUnit shows the unit of a counter or sets it, such as bytes for counters that si and iec formats
+// should print as 3.4GiB; none removes it
+func runUnit(args []string) error {
+	fs := newCommandFlags("unit")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		return errors.New("usage: counter unit <name> [unit|none]")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	name := positional[0]
+	path := counterPath(name)
+	if len(positional) == 1 {
+		meta, err := readMeta(path)
+		if err != nil {
+			return err
+		}
+		if meta.Unit == "" {
+			fmt.Println(UnitNone)
+		} else {
+			fmt.Println(meta.Unit)
+		}
+		return nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("counter %s does not exist", name)
+	}
+	unit := positional[1]
+	if unit == UnitNone {
+		unit = ""
+	} else if err := validUnit(unit); err != nil {
+		return err
+	}
+	err := editMeta(path, func(meta *counterMeta) error {
+		meta.Unit = unit
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("counter %s: %w", name, err)
+	}
+	if unit == "" {
+		fmt.Printf("counter %s has no unit\n", name)
+	} else {
+		fmt.Printf("counter %s is counted in %s\n", name, unit)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestValueFormats tests every -format against values with and without a unit
+func TestValueFormats(t *testing.T) {
+	bytes := counterMeta{Unit: UnitBytes}
+	requests := counterMeta{Unit: "requests"}
+	tests := []struct {
+		format string
+		value  string
+		meta   counterMeta
+		want   string
+	}{
+		{"raw", "3650722201", bytes, "3650722201"},
+		{"comma", "-1234567.50", counterMeta{}, "-1,234,567.50"},
+		{"comma", "999", counterMeta{}, "999"},
+		{"si", "1234567", counterMeta{}, "1.2M"},
+		{"si", "999960", counterMeta{}, "1M"},
+		{"si", "512", requests, "512 requests"},
+		{"iec", "3650722201", bytes, "3.4GiB"},
+		{"iec", "1024", counterMeta{}, "1Ki"},
+		{"auto", "3650722201", bytes, "3.4GiB"},
+		{"auto", "1234567", requests, "1.2M requests"},
+		{"auto", "1234567", counterMeta{}, "1234567"},
+		{"hex", "255", counterMeta{}, "0xff"},
+		{"hex", "-255", counterMeta{}, "-0xff"},
+		{"pad:6", "42", counterMeta{}, "000042"},
+		{"pad:6", "-42", counterMeta{}, "-00042"},
+		{"pad:2", "1234", counterMeta{}, "1234"},
+		{"{{.Name}}={{.Value}} {{.Unit}}", "42", bytes, "backups=42 bytes"},
+	}
+	for _, tt := range tests {
+		format, err := parseValueFormat(tt.format)
+		if err != nil {
+			t.Errorf("parseValueFormat(%q) failed: %v", tt.format, err)
+			continue
+		}
+		if got, err := format.apply("backups", tt.value, tt.meta); err != nil || got != tt.want {
+			t.Errorf("Expected %s in %s to be %q, got %q (%v)", tt.value, tt.format, tt.want, got, err)
+		}
+	}
+	for _, text := range []string{"human", "pad:0", "pad:x", "{{.Missing}}", "{{"} {
+		if _, err := parseValueFormat(text); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+	hex, _ := parseValueFormat("hex")
+	if _, err := hex.apply("price", "12.50", counterMeta{}); err == nil {
+		t.Errorf("Expected hex to reject a decimal value")
+	}
+}
+
+// TestValidUnit tests the validUnit function
+func TestValidUnit(t *testing.T) {
+	for _, unit := range []string{"bytes", "requests", "ms"} {
+		if err := validUnit(unit); err != nil {
+			t.Errorf("Expected %q to be valid: %v", unit, err)
+		}
+	}
+	for _, unit := range []string{"", "two words", "averyveryverylongunit"} {
+		if err := validUnit(unit); err == nil {
+			t.Errorf("Expected %q to be rejected", unit)
+		}
+	}
+}
+
+// TestMainCounterName tests the names of counters given with -name and -file
+func TestMainCounterName(t *testing.T) {
+	dir := useCounterDir(t)
+	previousName, previousFile := counterName, counterFile
+	t.Cleanup(func() { counterName, counterFile = previousName, previousFile })
+
+	counterName, counterFile = "jobs", DefaultCounterFile
+	if got := mainCounterName(); got != "jobs" {
+		t.Errorf("Expected jobs, got %q", got)
+	}
+	counterName, counterFile = DefaultCounterName, filepath.Join(dir, "team", "builds")
+	if got := mainCounterName(); got != filepath.Join("team", "builds") {
+		t.Errorf("Expected the path within the counter directory, got %q", got)
+	}
+	outside := filepath.Join(t.TempDir(), "builds")
+	counterFile = outside
+	if got := mainCounterName(); got != outside {
+		t.Errorf("Expected the absolute path, got %q", got)
+	}
+}
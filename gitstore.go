@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	GitStoreFile     string = ".gitstore.json"
+	GitLockFile      string = ".gitstore.lock"
+	DefaultGitRemote string = "origin"
+	DefaultMerge     string = "max"
+)
+
+// gitIgnored lists the runtime files of a counter directory that are never committed; hooks and
+// webhooks stay out of the repository because they run commands and hold secrets
+var gitIgnored = []string{
+	"*.lock", ".*.tmp*", ".outbox/", AuditFile, HooksFile, WebhooksFile,
+	"*.sem", "*.bucket", "*.history", "*.window", "*.archive", "*.tombstone",
+}
+
+// mergeStrategies describes how a counter that changed on both sides of a pull is resolved
+var mergeStrategies = map[string]string{
+	"pn":     "keep every increment of both sides (int counters only)",
+	"max":    "keep the larger value, for monotonic counters such as release numbers (default)",
+	"min":    "keep the smaller value",
+	"ours":   "keep the local value",
+	"theirs": "keep the value of the remote",
+	"union":  "combine the items of both sides (unique counters only)",
+}
+
+// gitStore is the configuration of a counter directory that is kept in a git working tree
+type gitStore struct {
+	Remote string `json:"remote,omitempty"`
+	Push   bool   `json:"push,omitempty"`
+}
+
+// loadGitStore reads the git configuration of dir; ok is false when dir is not kept in git
+func loadGitStore(dir string) (store gitStore, ok bool, err error) {
+	data, readErr := os.ReadFile(filepath.Join(dir, GitStoreFile))
+	if readErr != nil {
+		if errors.Is(readErr, fs.ErrNotExist) {
+			return store, false, nil
+		}
+		return store, false, readErr
+	}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return store, false, fmt.Errorf("invalid git store configuration: %w", err)
+	}
+	return store, true, nil
+}
+
+// gitIn runs git in dir and returns its trimmed standard output
+func gitIn(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var message []string
+		for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+			if !strings.HasPrefix(line, "hint:") {
+				message = append(message, line)
+			}
+		}
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.Join(message, "; "))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitIdentity returns the options that give git a committer identity when none is configured in dir
+func gitIdentity(dir string) []string {
+	if email, err := gitIn(dir, "config", "user.email"); err == nil && email != "" {
+		return nil
+	}
+	host, _ := os.Hostname()
+	return []string{"-c", "user.name=counter", "-c", "user.email=counter@" + host}
+}
+
+// gitCommit commits the staged changes in dir
+func gitCommit(dir string, args ...string) error {
+	_, err := gitIn(dir, append(append(gitIdentity(dir), "commit", "--quiet"), args...)...)
+	return err
+}
+
+// isRepoRoot reports whether dir is the top of its git working tree rather than a directory inside a
+// repository that holds other files
+func isRepoRoot(dir string) bool {
+	top, err := gitIn(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return false
+	}
+	want, wantErr := filepath.EvalSymlinks(dir)
+	got, gotErr := filepath.EvalSymlinks(top)
+	if wantErr != nil || gotErr != nil {
+		return false
+	}
+	return filepath.Clean(want) == filepath.Clean(got)
+}
+
+// commitAll stages every change in dir and commits it with message; only dir is committed, so changes
+// staged elsewhere in the repository stay out, and it reports whether anything was committed
+func commitAll(dir, message string) (bool, error) {
+	if _, err := gitIn(dir, "add", "--all", "--", "."); err != nil {
+		return false, err
+	}
+	staged, diffErr := gitIn(dir, "diff", "--cached", "--name-only", "--relative", "--", ".")
+	if diffErr != nil || staged == "" {
+		return false, diffErr
+	}
+	return true, gitCommit(dir, "-m", message, "--", ".")
+}
+
+// commitFiles stages the given files of dir, including their deletion, and commits only them with message;
+// files outside of dir are skipped, and it reports whether anything was committed
+func commitFiles(dir, message string, files ...string) (bool, error) {
+	var paths []string
+	for _, file := range files {
+		rel, relErr := filepath.Rel(dir, file)
+		if relErr != nil {
+			return false, relErr
+		}
+		if !filepath.IsLocal(rel) {
+			continue
+		}
+		// a file that neither exists nor was ever committed, such as a counter without metadata, has nothing to stage
+		if _, err := os.Stat(file); err != nil {
+			if _, err := gitIn(dir, "ls-files", "--error-unmatch", "--", rel); err != nil {
+				continue
+			}
+		}
+		paths = append(paths, rel)
+	}
+	if len(paths) == 0 {
+		return false, nil
+	}
+	if _, err := gitIn(dir, append([]string{"add", "--all", "--"}, paths...)...); err != nil {
+		return false, err
+	}
+	staged, diffErr := gitIn(dir, append([]string{"diff", "--cached", "--name-only", "--relative", "--"}, paths...)...)
+	if diffErr != nil || staged == "" {
+		return false, diffErr
+	}
+	return true, gitCommit(dir, append([]string{"-m", message, "--"}, paths...)...)
+}
+
+// commitChange commits a counter mutation as "name: old -> new" when the counter directory dir is kept in
+// git, and pushes it when the store is configured to; only the counter file, its metadata and the names
+// manifest are committed, so other changes in the directory stay out of the commit
+func commitChange(dir, name, filePath string, previous, value int64) error {
+	store, ok, loadErr := loadGitStore(dir)
+	if !ok || loadErr != nil {
+		return loadErr
+	}
+	unlock, lockErr := lockFile(filepath.Join(dir, GitLockFile))
+	if lockErr != nil {
+		return lockErr
+	}
+	defer unlock()
+	committed, commitErr := commitFiles(dir, fmt.Sprintf("%s: %d -> %d", name, previous, value),
+		filePath, metaPath(filePath), filepath.Join(dir, NamesFile))
+	if commitErr != nil || !committed || !store.Push {
+		return commitErr
+	}
+	if _, err := gitIn(dir, "push", "--quiet", store.remote(), "HEAD"); err != nil {
+		return fmt.Errorf("%w; run counter git pull to catch up", err)
+	}
+	return nil
+}
+
+// remote returns the name of the remote to pull from and push to
+func (s gitStore) remote() string {
+	if s.Remote == "" {
+		return DefaultGitRemote
+	}
+	return s.Remote
+}
+
+// resolveCounter picks the content of a counter file that changed on both sides of a pull
+func resolveCounter(ours, theirs string, meta counterMeta) (string, error) {
+	strategy := meta.mergeStrategy()
+	switch strategy {
+	case "ours":
+		return ours, nil
+	case "theirs":
+		return theirs, nil
+	case "pn":
+		if meta.typed() {
+			return "", fmt.Errorf("a %s counter cannot be merged with pn", meta.typeName())
+		}
+		state, err := parsePN(ours)
+		if err != nil {
+			return "", err
+		}
+		other, err := parsePN(theirs)
+		if err != nil {
+			return "", err
+		}
+		state.merge(other)
+		return state.String(), nil
+	case "union":
+		if meta.Type != TypeUnique {
+			return "", fmt.Errorf("a %s counter cannot be merged with union", meta.typeName())
+		}
+		sketch, err := parseSketch(ours)
+		if err != nil {
+			return "", err
+		}
+		other, err := parseSketch(theirs)
+		if err != nil {
+			return "", err
+		}
+		if _, err := sketch.merge(other); err != nil {
+			return "", err
+		}
+		return sketch.String(), nil
+	case "max", "min":
+		a, aErr := numericContent(ours, meta)
+		if aErr != nil {
+			return "", aErr
+		}
+		b, bErr := numericContent(theirs, meta)
+		if bErr != nil {
+			return "", bErr
+		}
+		if (a.Cmp(b) >= 0) == (strategy == "max") {
+			return ours, nil
+		}
+		return theirs, nil
+	}
+	return "", fmt.Errorf("unknown merge strategy %q", strategy)
+}
+
+// mergeStrategy returns how the counter is resolved when it changed on both sides of a pull
+func (m counterMeta) mergeStrategy() string {
+	switch {
+	case m.Merge != "":
+		return m.Merge
+	case m.Type == TypeUnique:
+		return "union"
+	case m.typed():
+		return "max"
+	}
+	return DefaultMerge
+}
+
+// numericContent returns the value held by the content of a counter file
+func numericContent(content string, meta counterMeta) (*big.Rat, error) {
+	if !meta.typed() {
+		state, err := parsePN(content)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Rat).SetInt(state.total()), nil
+	}
+	value, err := meta.normalize(content)
+	if err != nil {
+		return nil, err
+	}
+	return parseNumber(meta.display(value))
+}
+
+// conflictVersion returns one side of a conflicted file, or "" when that side deleted it
+func conflictVersion(dir, stage, file string) string {
+	content, err := gitIn(dir, "show", ":"+stage+":./"+file)
+	if err != nil {
+		return ""
+	}
+	return content
+}
+
+// resolveConflicts resolves every conflicted file of a merge in progress: counter files by their merge
+// strategy, the names manifest by taking the union of both sides and any other file by keeping ours
+func resolveConflicts(dir string) ([]string, error) {
+	conflicted, diffErr := gitIn(dir, "diff", "--name-only", "--relative", "--diff-filter=U")
+	if diffErr != nil {
+		return nil, diffErr
+	}
+	var files, counters []string
+	for _, file := range strings.Split(conflicted, "\n") {
+		if file == "" {
+			continue
+		}
+		if filepath.Dir(file) != "." || file == NamesFile || strings.HasPrefix(file, ".") && !strings.HasSuffix(file, ".counter") {
+			files = append(files, file)
+		} else {
+			counters = append(counters, file)
+		}
+	}
+
+	var resolved []string
+	for _, file := range files {
+		ours, theirs := conflictVersion(dir, "2", file), conflictVersion(dir, "3", file)
+		content := ours
+		if file == NamesFile {
+			names := map[string]string{}
+			_ = json.Unmarshal([]byte(theirs), &names)
+			_ = json.Unmarshal([]byte(ours), &names)
+			data, _ := json.MarshalIndent(names, "", "  ")
+			content = string(data)
+		} else if ours == "" {
+			content = theirs
+		}
+		if err := writeFileAtomic(filepath.Join(dir, file), []byte(content), 0600); err != nil {
+			return resolved, err
+		}
+		if _, err := gitIn(dir, "add", "--", file); err != nil {
+			return resolved, err
+		}
+	}
+
+	names, namesErr := loadNames(dir)
+	if namesErr != nil {
+		return resolved, namesErr
+	}
+	for _, file := range counters {
+		path := filepath.Join(dir, file)
+		name, _ := nameOf(file, names)
+		if name == "" {
+			name = file
+		}
+		meta, metaErr := readMeta(path)
+		if metaErr != nil {
+			return resolved, metaErr
+		}
+		ours, theirs := conflictVersion(dir, "2", file), conflictVersion(dir, "3", file)
+		// a deletion never wins over a change
+		content, resolveErr := ours+theirs, error(nil)
+		if ours != "" && theirs != "" {
+			content, resolveErr = resolveCounter(ours, theirs, meta)
+		}
+		if resolveErr != nil {
+			return resolved, fmt.Errorf("counter %s: %w", name, resolveErr)
+		}
+		if err := storeCounterText(path, content); err != nil {
+			return resolved, err
+		}
+		if _, err := gitIn(dir, "add", "--", file); err != nil {
+			return resolved, err
+		}
+		value, _ := peekValue(path)
+		resolved = append(resolved, fmt.Sprintf("%s: resolved to %s", name, value))
+	}
+	return resolved, nil
+}
+
+// pullGitStore fetches the current branch from the remote and merges it, resolving conflicts per counter
+func pullGitStore(dir string, store gitStore) ([]string, error) {
+	unlock, lockErr := lockFile(filepath.Join(dir, GitLockFile))
+	if lockErr != nil {
+		return nil, lockErr
+	}
+	defer unlock()
+	// a merge commits the whole repository, so it would take along changes made outside of dir
+	if !isRepoRoot(dir) {
+		return nil, fmt.Errorf("%s is not the top of its git repository; run counter git init to give it its own", dir)
+	}
+	if _, err := commitAll(dir, "counter: record local changes"); err != nil {
+		return nil, err
+	}
+	branch, branchErr := gitIn(dir, "symbolic-ref", "--short", "HEAD")
+	if branchErr != nil {
+		return nil, branchErr
+	}
+	heads, lsErr := gitIn(dir, "ls-remote", "--heads", store.remote(), branch)
+	if lsErr != nil {
+		return nil, lsErr
+	}
+	if heads == "" {
+		return nil, nil
+	}
+	if _, err := gitIn(dir, "fetch", "--quiet", store.remote(), branch); err != nil {
+		return nil, err
+	}
+	_, mergeErr := gitIn(dir, append(gitIdentity(dir), "merge", "--no-edit", "--quiet", "FETCH_HEAD")...)
+	if mergeErr == nil {
+		return nil, nil
+	}
+	if conflicted, err := gitIn(dir, "diff", "--name-only", "--diff-filter=U"); err != nil || conflicted == "" {
+		return nil, mergeErr
+	}
+	resolved, resolveErr := resolveConflicts(dir)
+	if resolveErr != nil {
+		_, _ = gitIn(dir, "merge", "--abort")
+		return nil, resolveErr
+	}
+	if err := gitCommit(dir, "--no-edit"); err != nil {
+		return resolved, err
+	}
+	return resolved, nil
+}
+
+// initGitStore keeps dir in a git working tree, creating the repository and ignore rules when needed; a
+// directory inside another repository gets a repository of its own
+func initGitStore(dir, remoteURL string, push bool) error {
+	if !isRepoRoot(dir) {
+		if _, err := gitIn(dir, "init", "--quiet"); err != nil {
+			return err
+		}
+	}
+	ignorePath := filepath.Join(dir, ".gitignore")
+	existing, _ := os.ReadFile(ignorePath)
+	ignore := string(existing)
+	for _, pattern := range gitIgnored {
+		if !strings.Contains("\n"+ignore, "\n"+pattern+"\n") {
+			if ignore != "" && !strings.HasSuffix(ignore, "\n") {
+				ignore += "\n"
+			}
+			ignore += pattern + "\n"
+		}
+	}
+	if err := os.WriteFile(ignorePath, []byte(ignore), 0644); err != nil {
+		return err
+	}
+
+	store, _, loadErr := loadGitStore(dir)
+	if loadErr != nil {
+		return loadErr
+	}
+	if remoteURL != "" {
+		if _, err := gitIn(dir, "remote", "get-url", store.remote()); err == nil {
+			_, err = gitIn(dir, "remote", "set-url", store.remote(), remoteURL)
+			if err != nil {
+				return err
+			}
+		} else if _, err := gitIn(dir, "remote", "add", store.remote(), remoteURL); err != nil {
+			return err
+		}
+	}
+	store.Push = push
+	data, marshalErr := json.MarshalIndent(store, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	if err := writeFileAtomic(filepath.Join(dir, GitStoreFile), data, 0644); err != nil {
+		return err
+	}
+	unlock, lockErr := lockFile(filepath.Join(dir, GitLockFile))
+	if lockErr != nil {
+		return lockErr
+	}
+	defer unlock()
+	_, err := commitAll(dir, "counter: keep counters in git")
+	return err
+}
+
+// runGitCommand keeps the counter directory in git and pulls, pushes or sets merge strategies
+func runGitCommand(args []string) error {
+	usage := errors.New("usage: counter git init [-remote url] [-push] | pull | push | strategy <name> [pn|max|min|ours|theirs]")
+	if len(args) == 0 {
+		return usage
+	}
+	var (
+		remoteURL string
+		push      bool
+	)
+	fs := newCommandFlags("git " + args[0])
+	if args[0] == "init" {
+		fs.StringVar(&remoteURL, "remote", "", "repository to pull from and push to, such as a local bare repository")
+		fs.BoolVar(&push, "push", false, "push after every commit")
+	}
+	positional, parseErr := parseCommandArgs(fs, args[1:])
+	if parseErr != nil {
+		return parseErr
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	if args[0] == "init" {
+		if len(positional) != 0 {
+			return usage
+		}
+		if err := initGitStore(counterDir, remoteURL, push); err != nil {
+			return err
+		}
+		fmt.Printf("counters in %s are kept in git\n", counterDir)
+		return nil
+	}
+	store, ok, loadErr := loadGitStore(counterDir)
+	if loadErr != nil {
+		return loadErr
+	}
+	if !ok {
+		return fmt.Errorf("counters in %s are not kept in git; run counter git init first", counterDir)
+	}
+
+	switch args[0] {
+	case "pull":
+		resolved, err := pullGitStore(counterDir, store)
+		for _, line := range resolved {
+			fmt.Println(line)
+		}
+		if err != nil || !store.Push {
+			return err
+		}
+		_, err = gitIn(counterDir, "push", "--quiet", store.remote(), "HEAD")
+		return err
+	case "push":
+		_, err := gitIn(counterDir, "push", "--quiet", store.remote(), "HEAD")
+		return err
+	case "strategy":
+		if len(positional) < 1 || len(positional) > 2 {
+			return usage
+		}
+		name := positional[0]
+		path := counterPath(name)
+		if len(positional) == 1 {
+			meta, err := readMeta(path)
+			if err != nil {
+				return err
+			}
+			strategy := meta.mergeStrategy()
+			fmt.Printf("counter %s merges by %s: %s\n", name, strategy, mergeStrategies[strategy])
+			return nil
+		}
+		strategy := positional[1]
+		if _, known := mergeStrategies[strategy]; !known {
+			return fmt.Errorf("unknown merge strategy %q: expected pn, max, min, ours, theirs or union", strategy)
+		}
+		editErr := editMeta(path, func(meta *counterMeta) error {
+			if strategy == "pn" && meta.typed() {
+				return fmt.Errorf("a %s counter cannot be merged with pn", meta.typeName())
+			}
+			if strategy == "union" && meta.Type != TypeUnique {
+				return fmt.Errorf("a %s counter cannot be merged with union", meta.typeName())
+			}
+			meta.Merge = strategy
+			return nil
+		})
+		if editErr != nil {
+			return editErr
+		}
+		if err := recordName(path, name); err != nil {
+			return err
+		}
+		fmt.Printf("counter %s merges by %s: %s\n", name, strategy, mergeStrategies[strategy])
+		return nil
+	}
+	return usage
+}
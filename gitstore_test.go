@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestResolveCounter tests the merge strategies applied to counters that changed on both sides of a pull
+func TestResolveCounter(t *testing.T) {
+	ours := `{"p":{"a":44}}`
+	theirs := `{"p":{"a":41,"b":2}}`
+	tests := map[string]int64{"pn": 46, "max": 44, "min": 43, "ours": 44, "theirs": 43}
+	for strategy, expected := range tests {
+		content, err := resolveCounter(ours, theirs, counterMeta{Merge: strategy})
+		if err != nil {
+			t.Errorf("resolveCounter(%s) failed: %v", strategy, err)
+			continue
+		}
+		if state, _ := parsePN(content); state.value() != expected {
+			t.Errorf("resolveCounter(%s) = %d, expected %d", strategy, state.value(), expected)
+		}
+	}
+	decimal := counterMeta{Type: TypeDecimal, Scale: 2}
+	if content, err := resolveCounter("9.50", "10.25", decimal); err != nil || content != "10.25" {
+		t.Errorf("Expected typed counters to keep the larger value by default, got %q (%v)", content, err)
+	}
+	if _, err := resolveCounter("9.50", "10.25", counterMeta{Type: TypeDecimal, Merge: "pn"}); err == nil {
+		t.Errorf("Expected pn to fail for a decimal counter")
+	}
+	left, right := newSketch(DefaultUniquePrecision), newSketch(DefaultUniquePrecision)
+	left.add("a")
+	right.add("b")
+	unique := counterMeta{Type: TypeUnique}
+	content, err := resolveCounter(left.String(), right.String(), unique)
+	if err != nil || unique.display(content) != "2" {
+		t.Errorf("Expected unique counters to be unioned by default, got %q (%v)", unique.display(content), err)
+	}
+}
+
+// TestGitStore tests that mutations are committed and pushed and that a pull resolves conflicting counters
+func TestGitStore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	if out, err := exec.Command("git", "init", "--quiet", "--bare", remote).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v: %s", err, out)
+	}
+	add := func(name string, amount int64) {
+		t.Helper()
+		if _, _, err := updateCounter(name, func(current int64) (int64, error) { return current + amount, nil }); err != nil {
+			t.Fatalf("updateCounter failed: %v", err)
+		}
+	}
+
+	a := useCounterDir(t)
+	useNode(t, "a")
+	if err := initGitStore(a, remote, true); err != nil {
+		t.Fatalf("initGitStore failed: %v", err)
+	}
+	add("builds", 41)
+	if err := editMeta(counterPath("builds"), func(meta *counterMeta) error {
+		meta.Merge = "max"
+		return nil
+	}); err != nil {
+		t.Fatalf("editMeta failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(a, "notes.txt"), []byte("draft"), 0600); err != nil {
+		t.Fatalf("failed to write notes: %v", err)
+	}
+	add("builds", 1)
+	if files, _ := gitIn(a, "show", "--name-only", "--format=", "HEAD"); strings.Contains(files, "notes.txt") {
+		t.Errorf("Expected the commit to hold only the counter, got %q", files)
+	}
+	log, _ := gitIn(a, "log", "--format=%s")
+	if !strings.HasPrefix(log, "builds: 41 -> 42\n") {
+		t.Errorf("Expected a commit per change, got %q", log)
+	}
+
+	b := filepath.Join(t.TempDir(), "b")
+	if out, err := exec.Command("git", "clone", "--quiet", remote, b).CombinedOutput(); err != nil {
+		t.Fatalf("git clone failed: %v: %s", err, out)
+	}
+	counterDir = b
+	useNode(t, "b")
+	add("builds", 1)
+	add("jobs", 5)
+
+	counterDir = a
+	useNode(t, "a")
+	add("builds", 2)
+	add("jobs", 3)
+	store, _, _ := loadGitStore(a)
+	resolved, err := pullGitStore(a, store)
+	if err != nil {
+		t.Fatalf("pullGitStore failed: %v", err)
+	}
+	if len(resolved) != 2 {
+		t.Errorf("Expected 2 resolved counters, got %v", resolved)
+	}
+	if value, _ := readCounter(counterPath("builds")); value != 44 {
+		t.Errorf("Expected builds to keep the larger value 44, got %d", value)
+	}
+	if value, _ := readCounter(counterPath("jobs")); value != 5 {
+		t.Errorf("Expected jobs to keep the larger value by default, got %d", value)
+	}
+	if status, _ := gitIn(a, "status", "--porcelain"); status != "" {
+		t.Errorf("Expected a clean working tree, got %q", status)
+	}
+}
+
+// TestGitStoreInsideRepository tests that a counter directory inside another repository never commits the
+// changes staged in that repository
+func TestGitStoreInsideRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	parent := t.TempDir()
+	if out, err := exec.Command("git", "init", "--quiet", parent).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v: %s", err, out)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "unrelated.txt"), []byte("draft"), 0600); err != nil {
+		t.Fatalf("failed to write unrelated.txt: %v", err)
+	}
+	if _, err := gitIn(parent, "add", "unrelated.txt"); err != nil {
+		t.Fatalf("git add failed: %v", err)
+	}
+	dir := filepath.Join(parent, "counters")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("failed to create %s: %v", dir, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "builds"), []byte("1\n"), 0600); err != nil {
+		t.Fatalf("failed to write builds: %v", err)
+	}
+
+	if _, err := commitAll(dir, "counter: record local changes"); err != nil {
+		t.Fatalf("commitAll failed: %v", err)
+	}
+	if files, _ := gitIn(parent, "show", "--name-only", "--format=", "HEAD"); files != "counters/builds" {
+		t.Errorf("Expected the commit to hold only the counter directory, got %q", files)
+	}
+	if _, err := pullGitStore(dir, gitStore{}); err == nil {
+		t.Errorf("Expected a pull into a directory inside another repository to fail")
+	}
+
+	if err := initGitStore(dir, "", false); err != nil {
+		t.Fatalf("initGitStore failed: %v", err)
+	}
+	if !isRepoRoot(dir) {
+		t.Errorf("Expected the counter directory to get a repository of its own")
+	}
+	if staged, _ := gitIn(parent, "diff", "--cached", "--name-only"); staged != "unrelated.txt" {
+		t.Errorf("Expected unrelated.txt to stay staged in the parent repository, got %q", staged)
+	}
+}
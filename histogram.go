@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	TypeHistogram           string  = "histogram"
+	DefaultHistogramStart   float64 = 0.005
+	DefaultHistogramFactor  float64 = 2
+	DefaultHistogramBuckets int     = 21
+	MaxHistogramBuckets     int     = 200
+	DefaultPercentiles      string  = ""
+)
+
+// histogram is the state of a histogram counter: the number of observations in every bucket, where
+// bucket i holds the values above bound i-1 up to and including bound i and the last bucket the values
+// above every bound, along with the count, sum, min and max of all observations
+type histogram struct {
+	Counts []uint64 `json:"counts"`
+	Count  uint64   `json:"count"`
+	Sum    float64  `json:"sum"`
+	Min    float64  `json:"min"`
+	Max    float64  `json:"max"`
+}
+
+// exponentialBuckets returns count bounds that start at start and grow by factor
+func exponentialBuckets(start, factor float64, count int) ([]float64, error) {
+	if start <= 0 || factor <= 1 || count < 1 || count > MaxHistogramBuckets {
+		return nil, fmt.Errorf("exponential buckets need a start above 0, a factor above 1 and 1 to %d buckets", MaxHistogramBuckets)
+	}
+	bounds := make([]float64, count)
+	for i := range bounds {
+		bounds[i] = start * math.Pow(factor, float64(i))
+	}
+	return bounds, nil
+}
+
+// defaultBuckets returns the bounds used when none are configured: 5ms doubling up to about 87 minutes
+func defaultBuckets() []float64 {
+	bounds, _ := exponentialBuckets(DefaultHistogramStart, DefaultHistogramFactor, DefaultHistogramBuckets)
+	return bounds
+}
+
+// parseBuckets parses bucket bounds given as a comma separated list of increasing numbers such as 1,5,10,30
+func parseBuckets(text string) ([]float64, error) {
+	var bounds []float64
+	for _, part := range strings.Split(text, ",") {
+		bound, err := parseFloat(part)
+		if err == nil && strings.TrimSpace(part) == "" {
+			err = errors.New("empty bound")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket bound %q: %w", part, err)
+		}
+		if len(bounds) > 0 && bound <= bounds[len(bounds)-1] {
+			return nil, errors.New("bucket bounds must increase")
+		}
+		bounds = append(bounds, bound)
+	}
+	if len(bounds) > MaxHistogramBuckets {
+		return nil, fmt.Errorf("at most %d buckets are allowed", MaxHistogramBuckets)
+	}
+	return bounds, nil
+}
+
+// parseExponential parses exponential buckets given as start,factor,count such as 0.1,2,12
+func parseExponential(text string) ([]float64, error) {
+	parts := strings.Split(text, ",")
+	if len(parts) != 3 {
+		return nil, errors.New("exponential buckets are given as start,factor,count")
+	}
+	start, startErr := parseFloat(parts[0])
+	factor, factorErr := parseFloat(parts[1])
+	count, countErr := strconv.Atoi(parts[2])
+	if err := errors.Join(startErr, factorErr, countErr); err != nil {
+		return nil, fmt.Errorf("invalid exponential buckets %q: %w", text, err)
+	}
+	return exponentialBuckets(start, factor, count)
+}
+
+// histogramBuckets returns the bucket bounds of a histogram counter
+func (m counterMeta) histogramBuckets() []float64 {
+	if len(m.Buckets) == 0 {
+		return defaultBuckets()
+	}
+	return m.Buckets
+}
+
+// parseHistogram decodes the content of a histogram counter file with the given bounds; an empty file or 0
+// is a histogram without observations
+func parseHistogram(text string, bounds []float64) (histogram, error) {
+	h := histogram{Counts: make([]uint64, len(bounds)+1)}
+	text = strings.TrimSpace(text)
+	if text == "" || text == "0" {
+		return h, nil
+	}
+	if err := json.Unmarshal([]byte(text), &h); err != nil {
+		return h, errors.New("histogram counters hold observations; add them with counter observe")
+	}
+	if len(h.Counts) != len(bounds)+1 {
+		return h, fmt.Errorf("histogram has %d buckets, expected %d", len(h.Counts), len(bounds)+1)
+	}
+	return h, nil
+}
+
+// histogramOfCount builds the histogram that another counter converts to: its whole, non-negative value
+// becomes the number of observations, which count as observations of 0 since their values are not known
+func histogramOfCount(text string, bounds []float64) (histogram, error) {
+	h := histogram{Counts: make([]uint64, len(bounds)+1)}
+	value, err := parseNumber(text)
+	if err != nil {
+		return h, err
+	}
+	if !value.IsInt() || value.Sign() < 0 || !value.Num().IsUint64() {
+		return h, fmt.Errorf("only a whole, non-negative value can become the number of observations, got %s", strings.TrimSpace(text))
+	}
+	h.Count = value.Num().Uint64()
+	h.Counts[sort.SearchFloat64s(bounds, 0)] = h.Count
+	return h, nil
+}
+
+// String encodes the histogram as the single line of JSON stored in the counter file
+func (h histogram) String() string {
+	data, _ := json.Marshal(h)
+	return string(data)
+}
+
+// observe records a value in the bucket of the smallest bound that is not below it
+func (h *histogram) observe(value float64, bounds []float64) error {
+	if math.IsInf(h.Sum+value, 0) {
+		return errors.New("sum does not fit a float64")
+	}
+	h.Counts[sort.SearchFloat64s(bounds, value)]++
+	if h.Count == 0 || value < h.Min {
+		h.Min = value
+	}
+	if h.Count == 0 || value > h.Max {
+		h.Max = value
+	}
+	h.Count++
+	h.Sum += value
+	return nil
+}
+
+// percentile estimates the value below which the fraction q of the observations fall, interpolating
+// linearly within the bucket that holds it and staying within the observed min and max
+func (h histogram) percentile(q float64, bounds []float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := q * float64(h.Count)
+	var seen uint64
+	for i, n := range h.Counts {
+		if n == 0 || float64(seen+n) < rank {
+			seen += n
+			continue
+		}
+		lower, upper := h.Min, h.Max
+		if i > 0 {
+			lower = math.Max(lower, bounds[i-1])
+		}
+		if i < len(bounds) {
+			upper = math.Min(upper, bounds[i])
+		}
+		return lower + (upper-lower)*(rank-float64(seen))/float64(n)
+	}
+	return h.Max
+}
+
+// parsePercentiles parses a list of percentiles such as 50,95,99.9
+func parsePercentiles(text string) ([]float64, error) {
+	var percentiles []float64
+	for _, part := range strings.Split(text, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid percentile %q: expected a number from 0 to 100", part)
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
+// readHistogram reads the state of a histogram counter file
+func readHistogram(filePath string, meta counterMeta) (histogram, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return histogram{}, fmt.Errorf("failed to read counter file: %w", err)
+	}
+	return parseHistogram(string(data), meta.histogramBuckets())
+}
+
+// printHistogram writes the count, sum, min and max of a histogram counter, or the requested percentiles
+func printHistogram(w io.Writer, filePath, percentiles string) error {
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return lockErr
+	}
+	meta, metaErr := readMeta(filePath)
+	if metaErr != nil {
+		unlock()
+		return metaErr
+	}
+	h, readErr := readHistogram(filePath, meta)
+	unlock()
+	if readErr != nil {
+		return readErr
+	}
+	if percentiles == DefaultPercentiles {
+		_, err := fmt.Fprintf(w, "count %d\nsum %s\nmin %s\nmax %s\n", h.Count, formatFloat(h.Sum), formatFloat(h.Min), formatFloat(h.Max))
+		return err
+	}
+	parsed, parseErr := parsePercentiles(percentiles)
+	if parseErr != nil {
+		return parseErr
+	}
+	for _, p := range parsed {
+		// estimates are rounded to thousandths, since interpolation is no more precise than that
+		value := math.Round(h.percentile(p/100, meta.histogramBuckets())*1000) / 1000
+		if _, err := fmt.Fprintf(w, "p%s %s\n", formatFloat(p), formatFloat(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observeValues records observations in a histogram counter, turning a counter that does not exist yet into
+// one with the default buckets, and returns the number of observations
+func observeValues(name string, values []float64) (string, error) {
+	filePath := counterPath(name)
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return "", lockErr
+	}
+	meta, metaErr := readMeta(filePath)
+	if metaErr == nil {
+		metaErr = requireWritable(meta)
+	}
+	if metaErr != nil {
+		unlock()
+		return "", metaErr
+	}
+	_, statErr := os.Stat(filePath)
+	created := meta.Type == "" && errors.Is(statErr, os.ErrNotExist)
+	if created {
+		meta.Type = TypeHistogram
+	}
+	if meta.Type != TypeHistogram {
+		unlock()
+		return "", fmt.Errorf("counter is a %s counter; convert it with counter type %s histogram", meta.typeName(), name)
+	}
+	current, readErr := readStored(filePath, meta)
+	if readErr != nil {
+		unlock()
+		return "", readErr
+	}
+	bounds := meta.histogramBuckets()
+	h, parseErr := parseHistogram(current, bounds)
+	if parseErr != nil {
+		unlock()
+		return "", parseErr
+	}
+	for _, value := range values {
+		if err := h.observe(value, bounds); err != nil {
+			unlock()
+			return "", err
+		}
+	}
+	next := h.String()
+	if created {
+		if err := writeMeta(filePath, meta); err != nil {
+			unlock()
+			return "", err
+		}
+	}
+	if err := storeCounterText(filePath, next); err != nil {
+		unlock()
+		return "", err
+	}
+	if err := recordName(filePath, name); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not record counter name: %v\n", err)
+	}
+	unlock()
+	afterMutation(counterDir, name, filePath, meta.wholeValue(current), meta.wholeValue(next))
+	return meta.display(next), nil
+}
+
+// runObserve records the values given as arguments, or one per line on stdin, in a histogram counter
+func runObserve(args []string) error {
+	fs := newCommandFlags("observe")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) < 1 {
+		return errors.New("usage: counter observe <name> [value...] (values are read from stdin when none are given)")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	name, texts := positional[0], positional[1:]
+	if len(texts) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				texts = append(texts, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read values: %w", err)
+		}
+	}
+	values := make([]float64, len(texts))
+	for i, text := range texts {
+		value, err := parseFloat(text)
+		if err == nil && strings.TrimSpace(text) == "" {
+			err = errors.New("empty value")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid observation %q: %w", text, err)
+		}
+		values[i] = value
+	}
+	count, observeErr := observeValues(name, values)
+	if observeErr != nil {
+		return fmt.Errorf("counter %s: %w", name, observeErr)
+	}
+	fmt.Println(count)
+	return nil
+}
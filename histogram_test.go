@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestParseBuckets tests the parseBuckets and parseExponential functions
+func TestParseBuckets(t *testing.T) {
+	if bounds, err := parseBuckets("1, 5,10,30"); err != nil || len(bounds) != 4 || bounds[3] != 30 {
+		t.Errorf("Expected four bounds, got %v (%v)", bounds, err)
+	}
+	for _, text := range []string{"5,1", "1,1", "1,x", ""} {
+		if _, err := parseBuckets(text); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+	if bounds, err := parseExponential("0.5,2,4"); err != nil || len(bounds) != 4 || bounds[3] != 4 {
+		t.Errorf("Expected 0.5, 1, 2 and 4, got %v (%v)", bounds, err)
+	}
+	for _, text := range []string{"0,2,4", "1,1,4", "1,2,0", "1,2"} {
+		if _, err := parseExponential(text); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+}
+
+// TestHistogramPercentiles tests observations and percentile estimates against a uniform distribution
+func TestHistogramPercentiles(t *testing.T) {
+	bounds, _ := parseBuckets("10,20,30,40,50,60,70,80,90,100")
+	h, _ := parseHistogram("", bounds)
+	for i := 1; i <= 100; i++ {
+		if err := h.observe(float64(i), bounds); err != nil {
+			t.Fatalf("observe failed: %v", err)
+		}
+	}
+	if h.Count != 100 || h.Sum != 5050 || h.Min != 1 || h.Max != 100 {
+		t.Errorf("Unexpected summary %+v", h)
+	}
+	for q, want := range map[float64]float64{0.5: 50, 0.95: 95, 0.99: 99, 1: 100, 0: 1} {
+		if got := h.percentile(q, bounds); math.Abs(got-want) > 1 {
+			t.Errorf("Expected p%v to be about %v, got %v", q*100, want, got)
+		}
+	}
+	if err := h.observe(math.MaxFloat64, bounds); err != nil {
+		t.Fatalf("observe failed: %v", err)
+	}
+	if err := h.observe(math.MaxFloat64, bounds); err == nil {
+		t.Errorf("Expected a sum that overflows to fail")
+	}
+	if _, err := parseHistogram(h.String(), bounds[1:]); err == nil {
+		t.Errorf("Expected a histogram with other buckets to be rejected")
+	}
+}
+
+// TestHistogramCounter tests observing values, printing the summary and percentiles and exporting histograms
+func TestHistogramCounter(t *testing.T) {
+	useCounterDir(t)
+	if count, err := observeValues("deploy.seconds", []float64{42, 3, 97}); err != nil || count != "3" {
+		t.Fatalf("Expected 3 observations, got %q (%v)", count, err)
+	}
+	if _, err := addTyped("deploy.seconds", "1"); err == nil {
+		t.Errorf("Expected adding a number to a histogram counter to fail")
+	}
+	var out bytes.Buffer
+	if err := printHistogram(&out, counterPath("deploy.seconds"), DefaultPercentiles); err != nil {
+		t.Fatalf("printHistogram failed: %v", err)
+	}
+	if want := "count 3\nsum 142\nmin 3\nmax 97\n"; out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+	out.Reset()
+	if err := printHistogram(&out, counterPath("deploy.seconds"), "50,100"); err != nil {
+		t.Fatalf("printHistogram failed: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "p50 ") || !strings.HasSuffix(out.String(), "p100 97\n") {
+		t.Errorf("Unexpected percentiles %q", out.String())
+	}
+
+	setCounters(t, map[string]int64{"deploys": 2})
+	entries, _ := nameIndex(func(string) bool { return true })
+	out.Reset()
+	if err := writeProm(&out, entries); err != nil {
+		t.Fatalf("writeProm failed: %v", err)
+	}
+	for _, line := range []string{
+		"# TYPE counter_histogram histogram",
+		`counter_histogram_bucket{name="deploy.seconds",le="0.005"} 0`,
+		`counter_histogram_bucket{name="deploy.seconds",le="+Inf"} 3`,
+		`counter_histogram_sum{name="deploy.seconds"} 142`,
+		`counter_histogram_count{name="deploy.seconds"} 3`,
+		`counter_value{name="deploys"} 2`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expected the export to contain %s, got:\n%s", line, out.String())
+		}
+	}
+}
+
+// TestConvertToHistogram tests that converting a counter to a histogram keeps its value as the count
+func TestConvertToHistogram(t *testing.T) {
+	useCounterDir(t)
+	setCounters(t, map[string]int64{"deploys": 5, "empty": 0})
+	if value, err := convertType(counterPath("deploys"), counterMeta{Type: TypeHistogram}); err != nil || value != "5" {
+		t.Fatalf("Expected a count of 5, got %q (%v)", value, err)
+	}
+	if count, err := observeValues("deploys", []float64{42}); err != nil || count != "6" {
+		t.Fatalf("Expected 6 observations, got %q (%v)", count, err)
+	}
+	if value, err := convertType(counterPath("empty"), counterMeta{Type: TypeHistogram}); err != nil || value != "0" {
+		t.Errorf("Expected a count of 0, got %q (%v)", value, err)
+	}
+
+	setCounters(t, map[string]int64{"debt": -2})
+	if _, err := convertType(counterPath("debt"), counterMeta{Type: TypeHistogram}); err == nil {
+		t.Errorf("Expected converting a negative counter to a histogram to fail")
+	}
+}
+
+// TestObserveConcurrently tests that concurrent observations of a new counter all land in one histogram
+func TestObserveConcurrently(t *testing.T) {
+	useCounterDir(t)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := observeValues("latency", []float64{float64(i)})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("observeValues failed: %v", err)
+		}
+	}
+	if value, err := readTyped(counterPath("latency")); err != nil || value != "8" {
+		t.Errorf("Expected 8 observations, got %q (%v)", value, err)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultHistoryRetention time.Duration = 7 * 24 * time.Hour
+	DefaultHistoryMaxBytes  int64         = 1 << 20
+	DefaultRateOver         time.Duration = 5 * time.Minute
+	DefaultTopBy            string        = "rate"
+	DefaultTopLimit         int           = 10
+)
+
+// historyEntry is a change of a counter: the time it was stored and the values before and after it
+type historyEntry struct {
+	At       time.Time
+	Previous int64
+	Value    int64
+}
+
+// historyPath returns the file that records the changes of a counter
+func historyPath(filePath string) string {
+	return sidecarPath(filePath, "history")
+}
+
+// String formats the entry as a line of the history file
+func (e historyEntry) String() string {
+	return fmt.Sprintf("%d %d %d\n", e.At.UnixNano(), e.Previous, e.Value)
+}
+
+// parseHistoryEntry parses a line of the history file
+func parseHistoryEntry(line string) (historyEntry, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return historyEntry{}, fmt.Errorf("invalid history entry %q", line)
+	}
+	at, atErr := strconv.ParseInt(fields[0], 10, 64)
+	previous, previousErr := strconv.ParseInt(fields[1], 10, 64)
+	value, valueErr := strconv.ParseInt(fields[2], 10, 64)
+	if err := errors.Join(atErr, previousErr, valueErr); err != nil {
+		return historyEntry{}, fmt.Errorf("invalid history entry %q", line)
+	}
+	return historyEntry{At: time.Unix(0, at), Previous: previous, Value: value}, nil
+}
+
+// readHistory reads the recorded changes of a counter sorted by time; lines that cannot be parsed, such as
+// one still being appended, are skipped
+func readHistory(filePath string) ([]historyEntry, error) {
+	file, openErr := os.Open(historyPath(filePath))
+	if openErr != nil {
+		if errors.Is(openErr, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read counter history: %w", openErr)
+	}
+	defer file.Close()
+	var entries []historyEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if entry, err := parseHistoryEntry(scanner.Text()); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read counter history: %w", err)
+	}
+	// processes append in the order they release the history lock, which may differ from the order of their clocks
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].At.Before(entries[j].At) })
+	return entries, nil
+}
+
+// recordHistory appends a change to the history of a counter, compacting the history once it grows
+// beyond DefaultHistoryMaxBytes
+func recordHistory(filePath string, previous, value int64) error {
+	if _, err := os.Stat(filePath); err != nil {
+		return nil
+	}
+	path := historyPath(filePath)
+	unlock, lockErr := lockFile(lockPath(path))
+	if lockErr != nil {
+		return lockErr
+	}
+	defer unlock()
+	file, openErr := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if openErr != nil {
+		return openErr
+	}
+	_, writeErr := file.WriteString(historyEntry{At: now(), Previous: previous, Value: value}.String())
+	info, statErr := file.Stat()
+	if err := errors.Join(writeErr, statErr, file.Close()); err != nil {
+		return err
+	}
+	if info.Size() <= DefaultHistoryMaxBytes {
+		return nil
+	}
+	return compactHistory(filePath)
+}
+
+// compactHistory drops the changes older than DefaultHistoryRetention, and the oldest changes until the
+// history is at most half of DefaultHistoryMaxBytes; the caller must hold the history lock
+func compactHistory(filePath string) error {
+	entries, readErr := readHistory(filePath)
+	if readErr != nil {
+		return readErr
+	}
+	oldest := now().Add(-DefaultHistoryRetention)
+	lines := make([]string, 0, len(entries))
+	size := int64(0)
+	for i := len(entries) - 1; i >= 0 && entries[i].At.After(oldest); i-- {
+		line := entries[i].String()
+		if size+int64(len(line)) > DefaultHistoryMaxBytes/2 {
+			break
+		}
+		lines = append(lines, line)
+		size += int64(len(line))
+	}
+	var b strings.Builder
+	for i := len(lines) - 1; i >= 0; i-- {
+		b.WriteString(lines[i])
+	}
+	return writeFileAtomic(historyPath(filePath), []byte(b.String()), 0600)
+}
+
+// increase returns how much a counter went up through the changes recorded within the last span; a change
+// that lowers the counter, such as a reset, counts as no increase, so that resets do not make the rate negative
+func increase(entries []historyEntry, span time.Duration) float64 {
+	since := now().Add(-span)
+	total := 0.0
+	for _, entry := range entries {
+		if entry.At.After(since) && entry.Value > entry.Previous {
+			total += float64(entry.Value) - float64(entry.Previous)
+		}
+	}
+	return total
+}
+
+// counterRate returns the average increase per second of a counter over the last span
+func counterRate(filePath string, span time.Duration) (float64, error) {
+	if span <= 0 {
+		return 0, errors.New("-over must be positive")
+	}
+	entries, err := readHistory(filePath)
+	if err != nil {
+		return 0, err
+	}
+	return increase(entries, span) / span.Seconds(), nil
+}
+
+// roundRate rounds a rate per second to millionths for output
+func roundRate(rate float64) float64 {
+	return math.Round(rate*1e6) / 1e6
+}
+
+// runRate prints the average increase per second of a counter over a recent span, like rate() in Prometheus
+func runRate(args []string) error {
+	over := DefaultRateOver
+	fs := newCommandFlags("rate")
+	fs.DurationVar(&over, "over", over, "span the rate is averaged over, such as 5m")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: counter rate <name> [-over D]")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	name := positional[0]
+	path := counterPath(name)
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("counter %s does not exist", name)
+	}
+	rate, rateErr := counterRate(path, over)
+	if rateErr != nil {
+		return fmt.Errorf("counter %s: %w", name, rateErr)
+	}
+	fmt.Println(formatRate(roundRate(rate)))
+	return nil
+}
+
+// rankedCounter is a counter along with the number it is ranked by in counter top
+type rankedCounter struct {
+	Entry indexEntry
+	Score float64
+}
+
+// rankCounters ranks the counters whose name matches a pattern by their rate over a span or by their value,
+// highest first and by name among equal scores
+func rankCounters(pattern, by string, over time.Duration) ([]rankedCounter, error) {
+	if by != "rate" && by != "value" {
+		return nil, fmt.Errorf("invalid ranking %q: expected rate or value", by)
+	}
+	entries, indexErr := nameIndex(func(name string) bool { return matchName(pattern, name) })
+	if indexErr != nil {
+		return nil, indexErr
+	}
+	ranked := make([]rankedCounter, 0, len(entries))
+	for _, entry := range entries {
+		var score float64
+		if by == "rate" {
+			rate, err := counterRate(entry.Path, over)
+			if err != nil {
+				return nil, fmt.Errorf("counter %s: %w", entry.Name, err)
+			}
+			score = rate
+		} else {
+			value, err := parseNumber(entry.Value)
+			if err != nil {
+				return nil, fmt.Errorf("counter %s: %w", entry.Name, err)
+			}
+			score, _ = value.Float64()
+		}
+		ranked = append(ranked, rankedCounter{Entry: entry, Score: score})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	return ranked, nil
+}
+
+// runTop prints the counters with the highest rate or value
+func runTop(args []string) error {
+	var (
+		by    = DefaultTopBy
+		over  = DefaultRateOver
+		limit = DefaultTopLimit
+		live  bool
+	)
+	fs := newCommandFlags("top")
+	fs.StringVar(&by, "by", by, "rank counters by rate or value")
+	fs.DurationVar(&over, "over", over, "span rates are averaged over, such as 5m")
+	fs.IntVar(&limit, "n", limit, "number of counters to print, 0 for all")
+	fs.BoolVar(&live, "live", live, "show a live dashboard instead, like counter ui")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) > 1 {
+		return errors.New("usage: counter top [pattern] [-by rate|value] [-over D] [-n N] [-live]")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	pattern := "*"
+	if len(positional) == 1 {
+		pattern = positional[0]
+	}
+	if live {
+		return runUI(pattern, by, over, DefaultUIInterval)
+	}
+	ranked, rankErr := rankCounters(pattern, by, over)
+	if rankErr != nil {
+		return rankErr
+	}
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	for _, counter := range ranked {
+		if by == "rate" {
+			fmt.Printf("%s\t%s\n", counter.Entry.Name, formatRate(roundRate(counter.Score)))
+		} else {
+			fmt.Printf("%s\t%s\n", counter.Entry.Name, counter.Entry.Value)
+		}
+	}
+	return nil
+}
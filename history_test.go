@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCounterRate tests that changes are recorded in the history and that rates ignore resets and old changes
+func TestCounterRate(t *testing.T) {
+	useCounterDir(t)
+	advance := useClock(t, time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	add := func(amount int64) {
+		t.Helper()
+		if _, _, err := updateCounter("requests", func(current int64) (int64, error) { return current + amount, nil }); err != nil {
+			t.Fatalf("failed to update the counter: %v", err)
+		}
+	}
+	add(1000)
+	advance(time.Hour)
+	for i := 0; i < 4; i++ {
+		add(30)
+		advance(time.Minute)
+	}
+	if _, _, err := updateCounter("requests", func(int64) (int64, error) { return 0, nil }); err != nil {
+		t.Fatalf("failed to reset the counter: %v", err)
+	}
+	add(60)
+
+	path := counterPath("requests")
+	if rate, err := counterRate(path, 5*time.Minute); err != nil || rate != 0.6 {
+		t.Errorf("Expected 180 over 5m to be 0.6/s, got %v (%v)", rate, err)
+	}
+	if rate, _ := counterRate(path, 2*time.Hour); rate != 1180.0/7200 {
+		t.Errorf("Expected the first change to count over 2h, got %v", rate)
+	}
+	if _, err := counterRate(path, 0); err == nil {
+		t.Errorf("Expected a span of 0 to be rejected")
+	}
+
+	advance(time.Hour)
+	if rate, _ := counterRate(path, 5*time.Minute); rate != 0 {
+		t.Errorf("Expected no rate an hour later, got %v", rate)
+	}
+}
+
+// TestHistoryOfFileCounter tests that the history of a counter given by its file is recorded beside that file
+func TestHistoryOfFileCounter(t *testing.T) {
+	useCounterDir(t)
+	path := filepath.Join(t.TempDir(), "builds")
+	if err := storeCounter(path, 3); err != nil {
+		t.Fatalf("failed to write counter: %v", err)
+	}
+	afterMutation(counterDir, path, path, 2, 3)
+	entries, err := readHistory(path)
+	if err != nil || len(entries) != 1 || entries[0].Previous != 2 || entries[0].Value != 3 {
+		t.Errorf("Expected the change in the history of %s, got %+v (%v)", path, entries, err)
+	}
+	if entries, _ := readHistory(counterPath(path)); len(entries) != 0 {
+		t.Errorf("Expected no history beside the hashed name, got %+v", entries)
+	}
+}
+
+// TestRankCounters tests ranking counters by rate and by value
+func TestRankCounters(t *testing.T) {
+	useCounterDir(t)
+	advance := useClock(t, time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	setCounters(t, map[string]int64{"api.idle": 5000})
+	advance(time.Hour)
+	for name, amount := range map[string]int64{"api.slow": 10, "api.busy": 600} {
+		if _, _, err := updateCounter(name, func(current int64) (int64, error) { return current + amount, nil }); err != nil {
+			t.Fatalf("failed to update the counter: %v", err)
+		}
+	}
+
+	ranked, err := rankCounters("api.*", "rate", time.Minute)
+	if err != nil || len(ranked) != 3 {
+		t.Fatalf("Expected three counters, got %v (%v)", ranked, err)
+	}
+	var names []string
+	for _, counter := range ranked {
+		names = append(names, counter.Entry.Name)
+	}
+	if got := strings.Join(names, ","); got != "api.busy,api.slow,api.idle" || ranked[0].Score != 10 {
+		t.Errorf("Expected api.busy at 10/s first, got %s (%v)", got, ranked[0].Score)
+	}
+	if ranked, _ := rankCounters("api.*", "value", time.Minute); ranked[0].Entry.Name != "api.idle" {
+		t.Errorf("Expected api.idle to have the highest value, got %s", ranked[0].Entry.Name)
+	}
+	if _, err := rankCounters("*", "name", time.Minute); err == nil {
+		t.Errorf("Expected an unknown ranking to be rejected")
+	}
+}
+
+// TestCompactHistory tests that compaction keeps recent changes and drops those beyond the retention
+func TestCompactHistory(t *testing.T) {
+	useCounterDir(t)
+	advance := useClock(t, time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	setCounters(t, map[string]int64{"jobs": 1})
+	path := counterPath("jobs")
+	if err := recordHistory(path, 0, 1); err != nil {
+		t.Fatalf("failed to record history: %v", err)
+	}
+	advance(DefaultHistoryRetention + time.Hour)
+	if err := recordHistory(path, 1, 2); err != nil {
+		t.Fatalf("failed to record history: %v", err)
+	}
+	if err := compactHistory(path); err != nil {
+		t.Fatalf("failed to compact history: %v", err)
+	}
+	entries, _ := readHistory(path)
+	if len(entries) != 1 || entries[0].Value != 2 {
+		t.Errorf("Expected only the recent change to remain, got %v", entries)
+	}
+	removeSidecars(path)
+	if _, err := os.Stat(historyPath(path)); !os.IsNotExist(err) {
+		t.Errorf("Expected the history to be removed with the counter")
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	TypeUnique              string = "unique"
+	DefaultUniquePrecision  uint8  = 14
+	MinUniquePrecision      uint8  = 4
+	MaxUniquePrecision      uint8  = 18
+	SketchPrefix            string = "hll"
+	DefaultUniqueMaxItemLen int    = 64 * 1024
+)
+
+// hllSketch is a HyperLogLog sketch: 2^p registers that each keep the longest run of leading zero bits
+// seen among the hashes of the items that fall into them, which estimates the number of distinct
+// items to within about 1.04/sqrt(2^p), 0.81% at the default precision of 14
+type hllSketch struct {
+	P         uint8
+	Registers []uint8
+}
+
+// newSketch returns an empty sketch with 2^p registers
+func newSketch(p uint8) hllSketch {
+	return hllSketch{P: p, Registers: make([]uint8, 1<<p)}
+}
+
+// hashItem hashes an item to 64 well mixed bits
+func hashItem(item string) uint64 {
+	h := fnv.New64a()
+	_, _ = io.WriteString(h, item)
+	x := h.Sum64()
+	// the splitmix64 finalizer spreads the bits of FNV, whose high bits are poorly mixed for short items
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// add records an item in the sketch and reports whether a register changed
+func (s hllSketch) add(item string) bool {
+	x := hashItem(item)
+	index := x >> (64 - s.P)
+	rank := uint8(bits.LeadingZeros64(x<<s.P|1<<(s.P-1)) + 1)
+	if rank > s.Registers[index] {
+		s.Registers[index] = rank
+		return true
+	}
+	return false
+}
+
+// merge folds other into the sketch, keeping the larger value of every register, so that the sketch
+// estimates the size of the union of both sets; it reports whether a register changed
+func (s hllSketch) merge(other hllSketch) (bool, error) {
+	if other.P != s.P {
+		return false, fmt.Errorf("cannot merge a sketch of precision %d into one of precision %d", other.P, s.P)
+	}
+	changed := false
+	for i, rank := range other.Registers {
+		if rank > s.Registers[i] {
+			s.Registers[i] = rank
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// estimate returns the estimated number of distinct items, using linear counting while many registers are empty
+func (s hllSketch) estimate() uint64 {
+	m := float64(len(s.Registers))
+	sum, zeros := 0.0, 0
+	for _, rank := range s.Registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// String encodes the sketch as the text stored in the counter file: the precision and the
+// compressed registers, which stay small while few registers are set
+func (s hllSketch) String() string {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	_, _ = w.Write(s.Registers)
+	_ = w.Close()
+	return fmt.Sprintf("%s:%d:%s", SketchPrefix, s.P, base64.RawStdEncoding.EncodeToString(buf.Bytes()))
+}
+
+// parseSketch decodes the content of a unique counter file; an empty file or 0 is an empty sketch
+func parseSketch(text string) (hllSketch, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || text == "0" {
+		return newSketch(DefaultUniquePrecision), nil
+	}
+	parts := strings.SplitN(text, ":", 3)
+	if len(parts) != 3 || parts[0] != SketchPrefix {
+		return hllSketch{}, errors.New("unique counters hold a sketch; add items with counter uadd")
+	}
+	p, pErr := strconv.ParseUint(parts[1], 10, 8)
+	if pErr != nil || uint8(p) < MinUniquePrecision || uint8(p) > MaxUniquePrecision {
+		return hllSketch{}, fmt.Errorf("invalid sketch precision %q", parts[1])
+	}
+	data, decodeErr := base64.RawStdEncoding.DecodeString(parts[2])
+	if decodeErr != nil {
+		return hllSketch{}, fmt.Errorf("invalid sketch: %w", decodeErr)
+	}
+	sketch := newSketch(uint8(p))
+	r := flate.NewReader(bytes.NewReader(data))
+	defer func() { _ = r.Close() }()
+	if _, err := io.ReadFull(r, sketch.Registers); err != nil {
+		return hllSketch{}, fmt.Errorf("invalid sketch: %w", err)
+	}
+	return sketch, nil
+}
+
+// readSketch reads the sketch of a unique counter file, returning an empty sketch when the file does not exist
+func readSketch(filePath string) (hllSketch, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return hllSketch{}, fmt.Errorf("failed to read counter file: %w", err)
+	}
+	return parseSketch(string(data))
+}
+
+// addUnique unions a sketch of new items into the sketch of a unique counter, turning a counter that does
+// not exist yet into one, and returns the estimated number of distinct items; the type and the sketch are
+// changed under the same lock, so a concurrent write cannot land between them
+func addUnique(name string, items hllSketch) (string, error) {
+	filePath := counterPath(name)
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return "", lockErr
+	}
+	meta, metaErr := readMeta(filePath)
+	if metaErr != nil {
+		unlock()
+		return "", metaErr
+	}
+	_, statErr := os.Stat(filePath)
+	created := meta.Type == "" && errors.Is(statErr, os.ErrNotExist)
+	if created {
+		meta.Type = TypeUnique
+	}
+	if meta.Type != TypeUnique {
+		unlock()
+		return "", fmt.Errorf("counter is a %s counter; convert it with counter type %s unique", meta.typeName(), name)
+	}
+	current, readErr := readStored(filePath, meta)
+	if readErr != nil {
+		unlock()
+		return "", readErr
+	}
+	sketch, parseErr := parseSketch(current)
+	if parseErr != nil {
+		unlock()
+		return "", parseErr
+	}
+	if _, err := sketch.merge(items); err != nil {
+		unlock()
+		return "", err
+	}
+	next := sketch.String()
+	if created {
+		if err := writeMeta(filePath, meta); err != nil {
+			unlock()
+			return "", err
+		}
+	}
+	if err := storeCounterText(filePath, next); err != nil {
+		unlock()
+		return "", err
+	}
+	if err := recordName(filePath, name); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not record counter name: %v\n", err)
+	}
+	unlock()
+	afterMutation(counterDir, name, filePath, meta.wholeValue(current), meta.wholeValue(next))
+	return meta.display(next), nil
+}
+
+// mergeUnique unions the sketch of a unique counter from another directory into the local counter file
+func mergeUnique(filePath, name string, named bool, remote hllSketch, dryRun bool) (mergeResult, error) {
+	result := mergeResult{Name: name}
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return result, lockErr
+	}
+	meta, metaErr := readMeta(filePath)
+	if metaErr != nil {
+		unlock()
+		return result, metaErr
+	}
+	if _, err := os.Stat(filePath); err == nil && meta.Type != TypeUnique {
+		unlock()
+		return result, fmt.Errorf("a %s counter cannot be merged with a unique counter", meta.typeName())
+	}
+	sketch, readErr := readSketch(filePath)
+	if readErr != nil {
+		unlock()
+		return result, readErr
+	}
+	result.Old = clampEstimate(sketch.estimate())
+	changed, mergeErr := sketch.merge(remote)
+	if mergeErr != nil {
+		unlock()
+		return result, mergeErr
+	}
+	result.Changed = changed || meta.Type != TypeUnique
+	result.New = clampEstimate(sketch.estimate())
+	if !result.Changed || dryRun {
+		unlock()
+		return result, nil
+	}
+	if meta.Type != TypeUnique {
+		meta.Type = TypeUnique
+		if err := writeMeta(filePath, meta); err != nil {
+			unlock()
+			return result, err
+		}
+	}
+	if err := storeCounterText(filePath, sketch.String()); err != nil {
+		unlock()
+		return result, err
+	}
+	if named {
+		if err := recordName(filePath, name); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not record counter name: %v\n", err)
+		}
+	}
+	unlock()
+	if result.Old != result.New {
+		afterMutation(filepath.Dir(filePath), name, filePath, result.Old, result.New)
+	}
+	return result, nil
+}
+
+// mergeUniqueFile merges the unique counter stored in remotePath into the counter file of the same name in dir
+func mergeUniqueFile(remotePath, dir string, counter dirCounter, dryRun bool) (mergeResult, error) {
+	remote, err := readSketch(remotePath)
+	if err != nil {
+		return mergeResult{Name: counter.Name}, err
+	}
+	return mergeUnique(filepath.Join(dir, counter.File), counter.Name, counter.Named, remote, dryRun)
+}
+
+// clampEstimate converts an estimate to the int64 that hooks and webhooks see
+func clampEstimate(estimate uint64) int64 {
+	if estimate > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(estimate)
+}
+
+// runUniqueAdd adds the items given as arguments, or one per line on stdin, to a unique counter
+func runUniqueAdd(args []string) error {
+	fs := newCommandFlags("uadd")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) < 1 {
+		return errors.New("usage: counter uadd <name> [value...] (values are read from stdin when none are given)")
+	}
+	if neverAdd {
+		return errors.New("add operation is disabled by the environment variable")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	// items are hashed into a sketch of their own first, so the counter is only locked for the union
+	name, items := positional[0], newSketch(DefaultUniquePrecision)
+	for _, item := range positional[1:] {
+		items.add(item)
+	}
+	if len(positional) == 1 {
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 0, 4096), DefaultUniqueMaxItemLen)
+		for scanner.Scan() {
+			if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+				items.add(line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read values: %w", err)
+		}
+	}
+	value, addErr := addUnique(name, items)
+	if addErr != nil {
+		return fmt.Errorf("counter %s: %w", name, addErr)
+	}
+	fmt.Println(value)
+	return nil
+}
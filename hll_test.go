@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestSketchAccuracy tests the estimates of the sketch against known cardinalities
+func TestSketchAccuracy(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 100, 1000, 10000, 100000, 1000000} {
+		sketch := newSketch(DefaultUniquePrecision)
+		for i := 0; i < n; i++ {
+			sketch.add("visitor-" + strconv.Itoa(i))
+			if i%3 == 0 {
+				sketch.add("visitor-" + strconv.Itoa(i)) // duplicates must not count
+			}
+		}
+		got := float64(sketch.estimate())
+		// three standard errors of 1.04/sqrt(2^14), and exact counts for tiny sets
+		tolerance := math.Max(3*1.04/128*float64(n), 1)
+		if math.Abs(got-float64(n)) > tolerance {
+			t.Errorf("Expected about %d distinct items, got %.0f", n, got)
+		}
+	}
+}
+
+// TestSketchEncoding tests that a sketch survives being stored as text
+func TestSketchEncoding(t *testing.T) {
+	sketch := newSketch(DefaultUniquePrecision)
+	for i := 0; i < 5000; i++ {
+		sketch.add(strconv.Itoa(i))
+	}
+	decoded, err := parseSketch(sketch.String())
+	if err != nil {
+		t.Fatalf("parseSketch failed: %v", err)
+	}
+	if decoded.estimate() != sketch.estimate() {
+		t.Errorf("Expected %d after decoding, got %d", sketch.estimate(), decoded.estimate())
+	}
+	if empty, err := parseSketch(""); err != nil || empty.estimate() != 0 {
+		t.Errorf("Expected an empty file to be an empty sketch, got %d (%v)", empty.estimate(), err)
+	}
+	for _, text := range []string{"42", "hll:2:AA", "hll:14:!!"} {
+		if _, err := parseSketch(text); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+}
+
+// TestSketchMerge tests that merging sketches estimates the size of the union
+func TestSketchMerge(t *testing.T) {
+	a, b := newSketch(DefaultUniquePrecision), newSketch(DefaultUniquePrecision)
+	for i := 0; i < 6000; i++ {
+		a.add(strconv.Itoa(i))
+	}
+	for i := 4000; i < 10000; i++ {
+		b.add(strconv.Itoa(i))
+	}
+	if changed, err := a.merge(b); err != nil || !changed {
+		t.Fatalf("Expected the merge to change the sketch (%v)", err)
+	}
+	if got := float64(a.estimate()); math.Abs(got-10000) > 300 {
+		t.Errorf("Expected about 10000 distinct items, got %.0f", got)
+	}
+	if changed, _ := a.merge(b); changed {
+		t.Errorf("Expected merging twice to change nothing")
+	}
+	if _, err := a.merge(newSketch(10)); err == nil {
+		t.Errorf("Expected sketches of different precision not to merge")
+	}
+}
+
+// TestUniqueCounter tests adding items to a unique counter and merging it from another directory
+func TestUniqueCounter(t *testing.T) {
+	dir := useCounterDir(t)
+	items := newSketch(DefaultUniquePrecision)
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1", "10.0.0.3"} {
+		items.add(ip)
+	}
+	if value, err := addUnique("visitors", items); err != nil || value != "3" {
+		t.Fatalf("Expected 3 visitors, got %q (%v)", value, err)
+	}
+	if value, err := readTyped(counterPath("visitors")); err != nil || value != "3" {
+		t.Errorf("Expected get to print 3, got %q (%v)", value, err)
+	}
+	if _, err := addTyped("visitors", "1"); err == nil {
+		t.Errorf("Expected adding a number to a unique counter to fail")
+	}
+
+	other := t.TempDir()
+	counterDir = other
+	more := newSketch(DefaultUniquePrecision)
+	more.add("10.0.0.3")
+	more.add("10.0.0.4")
+	if _, err := addUnique("visitors", more); err != nil {
+		t.Fatalf("addUnique failed: %v", err)
+	}
+	counterDir = dir
+	results, err := mergeDir(other, dir, false)
+	if err != nil || len(results) != 1 || results[0].New != 4 {
+		t.Fatalf("Expected the merge to make 4 visitors, got %+v (%v)", results, err)
+	}
+	if value, _ := readTyped(filepath.Join(dir, generateCounterFileName("visitors"))); value != "4" {
+		t.Errorf("Expected 4 visitors after the merge, got %q", value)
+	}
+}
+
+// TestAddUniqueConcurrently tests that concurrent additions to a new unique counter all land in its sketch
+func TestAddUniqueConcurrently(t *testing.T) {
+	useCounterDir(t)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			items := newSketch(DefaultUniquePrecision)
+			items.add("visitor-" + strconv.Itoa(i))
+			_, err := addUnique("visitors", items)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("addUnique failed: %v", err)
+		}
+	}
+	if value, err := readTyped(counterPath("visitors")); err != nil || value != "8" {
+		t.Errorf("Expected 8 visitors, got %q (%v)", value, err)
+	}
+}
+
+// TestUniqueAddNeverAdd tests that uadd is refused when adding is disabled
+func TestUniqueAddNeverAdd(t *testing.T) {
+	useCounterDir(t)
+	neverAdd = true
+	t.Cleanup(func() { neverAdd = DefaultNeverAdd })
+	if err := runUniqueAdd([]string{"visitors", "alice"}); err == nil {
+		t.Errorf("Expected uadd to be rejected when adding is disabled")
+	}
+	if _, err := os.Stat(counterPath("visitors")); !os.IsNotExist(err) {
+		t.Errorf("Expected no counter to be created, got %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HooksFile          string        = ".hooks.json"
+	DefaultHookTimeout time.Duration = 30 * time.Second
+)
+
+// trigger decides whether a change from one value to another should fire an action
+type trigger struct {
+	op string
+	n  int64
+}
+
+// parseTrigger parses conditions such as ">= 100", "< 0", "every 1000" or "changed"
+func parseTrigger(when string) (trigger, error) {
+	fields := strings.Fields(when)
+	if len(fields) == 1 && fields[0] == "changed" {
+		return trigger{op: "changed"}, nil
+	}
+	if len(fields) == 1 {
+		// allow the operator and value to be written without a space, as in ">=100"
+		for _, op := range []string{">=", "<=", "==", ">", "<"} {
+			if strings.HasPrefix(fields[0], op) {
+				fields = []string{op, strings.TrimPrefix(fields[0], op)}
+				break
+			}
+		}
+	}
+	if len(fields) != 2 {
+		return trigger{}, fmt.Errorf("invalid condition %q", when)
+	}
+	n, parseErr := strconv.ParseInt(fields[1], 10, 64)
+	if parseErr != nil {
+		return trigger{}, fmt.Errorf("invalid condition %q: %w", when, parseErr)
+	}
+	switch fields[0] {
+	case ">=", ">", "<=", "<", "==":
+		return trigger{op: fields[0], n: n}, nil
+	case "every":
+		if n <= 0 {
+			return trigger{}, fmt.Errorf("invalid condition %q: every requires a positive value", when)
+		}
+		return trigger{op: fields[0], n: n}, nil
+	}
+	return trigger{}, fmt.Errorf("invalid condition %q", when)
+}
+
+// holds reports whether value satisfies a comparison trigger
+func (t trigger) holds(value int64) bool {
+	switch t.op {
+	case ">=":
+		return value >= t.n
+	case ">":
+		return value > t.n
+	case "<=":
+		return value <= t.n
+	case "<":
+		return value < t.n
+	case "==":
+		return value == t.n
+	}
+	return false
+}
+
+// fires reports whether the change from previous to value crosses the trigger
+func (t trigger) fires(previous, value int64) bool {
+	if previous == value {
+		return false
+	}
+	switch t.op {
+	case "changed":
+		return true
+	case "every":
+		return floorDiv(previous, t.n) != floorDiv(value, t.n)
+	}
+	return t.holds(value) && !t.holds(previous)
+}
+
+// floorDiv divides a by b rounding towards negative infinity
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && ((a < 0) != (b < 0)) {
+		q--
+	}
+	return q
+}
+
+// hook is a command that runs when a counter crosses a condition
+type hook struct {
+	ID      int           `json:"id"`
+	Counter string        `json:"counter"`
+	When    string        `json:"when"`
+	Exec    string        `json:"exec"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+// loadHooks reads the hooks configured in dir
+func loadHooks(dir string) ([]hook, error) {
+	var hooks []hook
+	data, err := os.ReadFile(filepath.Join(dir, HooksFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return hooks, nil
+		}
+		return nil, fmt.Errorf("failed to read hooks: %w", err)
+	}
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("invalid hooks file: %w", err)
+	}
+	return hooks, nil
+}
+
+// editHooks applies fn to the hooks in counterDir while holding the hooks lock and saves the result
+func editHooks(fn func([]hook) ([]hook, error)) error {
+	path := filepath.Join(counterDir, HooksFile)
+	unlock, lockErr := lockFile(path + ".lock")
+	if lockErr != nil {
+		return lockErr
+	}
+	defer unlock()
+	hooks, loadErr := loadHooks(counterDir)
+	if loadErr != nil {
+		return loadErr
+	}
+	hooks, fnErr := fn(hooks)
+	if fnErr != nil {
+		return fnErr
+	}
+	data, marshalErr := json.MarshalIndent(hooks, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// fireHooks runs every hook of dir whose counter pattern matches name and whose condition the change crosses
+func fireHooks(dir, name string, previous, value int64) error {
+	if previous == value {
+		return nil
+	}
+	hooks, loadErr := loadHooks(dir)
+	if loadErr != nil {
+		return loadErr
+	}
+	for _, h := range hooks {
+		if !matchName(h.Counter, name) {
+			continue
+		}
+		t, parseErr := parseTrigger(h.When)
+		if parseErr != nil || !t.fires(previous, value) {
+			continue
+		}
+		runHook(dir, h, name, previous, value)
+	}
+	return nil
+}
+
+// runHook executes a hook with the change described in its environment and records the outcome in the audit log of dir
+func runHook(dir string, h hook, name string, previous, value int64) {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Exec)
+	cmd.Env = append(os.Environ(),
+		"COUNTER_NAME="+name,
+		"COUNTER_OLD="+strconv.FormatInt(previous, 10),
+		"COUNTER_NEW="+strconv.FormatInt(value, 10),
+		"COUNTER_HOOK_ID="+strconv.Itoa(h.ID),
+		"COUNTER_HOOK_WHEN="+h.When,
+	)
+	cmd.WaitDelay = time.Second
+	output, runErr := cmd.CombinedOutput()
+	status := "succeeded"
+	if ctx.Err() != nil {
+		status = fmt.Sprintf("timed out after %s", timeout)
+	} else if runErr != nil {
+		status = fmt.Sprintf("failed: %v", runErr)
+	}
+	entry := fmt.Sprintf("hook %d %s for %s (%s) %d -> %d", h.ID, status, name, h.When, previous, value)
+	if len(output) > 0 {
+		entry += "\n" + string(output)
+	}
+	if err := auditLog(dir, "%s", entry); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not write audit log: %v\n", err)
+	}
+}
+
+// runHookCommand manages the hooks attached to counters
+func runHookCommand(args []string) error {
+	usage := errors.New("usage: counter hook add <name> -when <condition> -exec <command> [-timeout D] | list [pattern] | remove <id>")
+	if len(args) == 0 {
+		return usage
+	}
+	var (
+		when    string
+		command string
+		timeout = DefaultHookTimeout
+	)
+	fs := newCommandFlags("hook " + args[0])
+	if args[0] == "add" {
+		fs.StringVar(&when, "when", "", "condition such as '>= 100', 'every 1000' or 'changed'")
+		fs.StringVar(&command, "exec", "", "shell command to run when the condition is crossed")
+		fs.DurationVar(&timeout, "timeout", timeout, "time allowed for the command to finish")
+	}
+	positional, parseErr := parseCommandArgs(fs, args[1:])
+	if parseErr != nil {
+		return parseErr
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		if len(positional) != 1 || command == "" {
+			return usage
+		}
+		if _, err := parseTrigger(when); err != nil {
+			return err
+		}
+		h := hook{ID: 1, Counter: positional[0], When: when, Exec: command, Timeout: timeout}
+		editErr := editHooks(func(hooks []hook) ([]hook, error) {
+			for _, existing := range hooks {
+				if existing.ID >= h.ID {
+					h.ID = existing.ID + 1
+				}
+			}
+			return append(hooks, h), nil
+		})
+		if editErr != nil {
+			return editErr
+		}
+		fmt.Printf("hook %d added to %s\n", h.ID, h.Counter)
+		return nil
+	case "list":
+		pattern := "*"
+		if len(positional) == 1 {
+			pattern = positional[0]
+		}
+		hooks, loadErr := loadHooks(counterDir)
+		if loadErr != nil {
+			return loadErr
+		}
+		for _, h := range hooks {
+			if matchName(pattern, h.Counter) || h.Counter == pattern {
+				fmt.Printf("%d\t%s\t%s\t%s\t%s\n", h.ID, h.Counter, h.When, h.Timeout, h.Exec)
+			}
+		}
+		return nil
+	case "remove":
+		if len(positional) != 1 {
+			return usage
+		}
+		id, idErr := strconv.Atoi(positional[0])
+		if idErr != nil {
+			return fmt.Errorf("invalid hook id %q", positional[0])
+		}
+		editErr := editHooks(func(hooks []hook) ([]hook, error) {
+			for i, h := range hooks {
+				if h.ID == id {
+					return append(hooks[:i], hooks[i+1:]...), nil
+				}
+			}
+			return nil, fmt.Errorf("hook %d does not exist", id)
+		})
+		if editErr != nil {
+			return editErr
+		}
+		fmt.Printf("hook %d removed\n", id)
+		return nil
+	}
+	return usage
+}
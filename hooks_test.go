@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// useCounterDir points counterDir at a temporary directory for the duration of the test
+func useCounterDir(t *testing.T) string {
+	t.Helper()
+	previous := counterDir
+	counterDir = t.TempDir()
+	t.Cleanup(func() { counterDir = previous })
+	return counterDir
+}
+
+// TestTriggerFires tests the parseTrigger and trigger.fires functions
+func TestTriggerFires(t *testing.T) {
+	tests := []struct {
+		when            string
+		previous, value int64
+		want            bool
+	}{
+		{">= 100", 99, 100, true},
+		{">= 100", 100, 101, false},
+		{">= 100", 101, 99, false},
+		{">=100", 50, 150, true},
+		{"< 0", 1, -1, true},
+		{"== 5", 4, 5, true},
+		{"== 5", 5, 5, false},
+		{"every 1000", 999, 1000, true},
+		{"every 1000", 1000, 1999, false},
+		{"every 1000", 1, -1, true},
+		{"changed", 1, 2, true},
+		{"changed", 2, 2, false},
+	}
+	for _, tt := range tests {
+		trig, err := parseTrigger(tt.when)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.when, err)
+		}
+		if got := trig.fires(tt.previous, tt.value); got != tt.want {
+			t.Errorf("%s: %d -> %d expected %v, got %v", tt.when, tt.previous, tt.value, tt.want, got)
+		}
+	}
+	for _, when := range []string{"", "every 0", "~ 3", ">= x"} {
+		if _, err := parseTrigger(when); err == nil {
+			t.Errorf("Expected error for condition %q", when)
+		}
+	}
+}
+
+// TestFireHooksOnce tests that a hook fires exactly once when concurrent updates cross its condition
+func TestFireHooksOnce(t *testing.T) {
+	dir := useCounterDir(t)
+	output := filepath.Join(dir, "fired")
+	err := editHooks(func(hooks []hook) ([]hook, error) {
+		return append(hooks, hook{ID: 1, Counter: "errors", When: ">= 10", Exec: `echo "$COUNTER_NAME $COUNTER_OLD $COUNTER_NEW" >> ` + output}), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to add hook: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := updateCounter("errors", func(current int64) (int64, error) { return current + 1, nil }); err != nil {
+				t.Errorf("failed to update counter: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	fired, readErr := os.ReadFile(output)
+	if readErr != nil {
+		t.Fatalf("Expected the hook to run: %v", readErr)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(fired)), "\n"); len(lines) != 1 || lines[0] != "errors 9 10" {
+		t.Errorf("Expected a single firing for 9 -> 10, got %q", fired)
+	}
+	audit, auditErr := os.ReadFile(filepath.Join(dir, AuditFile))
+	if auditErr != nil || !strings.Contains(string(audit), "hook 1 succeeded for errors (>= 10) 9 -> 10") {
+		t.Errorf("Expected the audit log to record the hook, got %q (%v)", audit, auditErr)
+	}
+}
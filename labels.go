@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// labelKey is the form of a label key, which is also a valid Prometheus label name
+var labelKey = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are keys that exports use for their own labels; le holds the bound of histogram buckets
+var reservedLabels = map[string]bool{"name": true, "le": true}
+
+// validLabel checks that a label can be stored, selected and exported
+func validLabel(key, value string) error {
+	if !labelKey.MatchString(key) || strings.HasPrefix(key, "__") {
+		return fmt.Errorf("invalid label key %q: use letters, digits and underscores", key)
+	}
+	if reservedLabels[key] {
+		return fmt.Errorf("label key %q is reserved", key)
+	}
+	if strings.ContainsAny(value, ",\n") {
+		return fmt.Errorf("invalid value for label %s: commas and newlines are not allowed", key)
+	}
+	return nil
+}
+
+// parseLabels parses key=value arguments into labels
+func parseLabels(args []string) (map[string]string, error) {
+	labels := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q: expected key=value", arg)
+		}
+		if err := validLabel(key, value); err != nil {
+			return nil, err
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// formatLabels formats labels as key=value pairs sorted by key and separated by commas
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + labels[key]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// labelRequirement is a single condition of a label selector
+type labelRequirement struct {
+	Key    string
+	Value  string
+	Negate bool
+	Exists bool
+}
+
+// labelSelector selects counters whose labels meet every requirement
+type labelSelector []labelRequirement
+
+// parseSelector parses a selector such as env=prod,owner!=infra; a bare key requires the label
+// to be present and !key requires it to be absent
+func parseSelector(text string) (labelSelector, error) {
+	var selector labelSelector
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var req labelRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			req.Key, req.Value, _ = strings.Cut(part, "!=")
+			req.Negate = true
+		case strings.Contains(part, "="):
+			req.Key, req.Value, _ = strings.Cut(part, "=")
+		case strings.HasPrefix(part, "!"):
+			req.Key, req.Exists, req.Negate = part[1:], true, true
+		default:
+			req.Key, req.Exists = part, true
+		}
+		req.Key = strings.TrimSpace(req.Key)
+		req.Value = strings.TrimSpace(req.Value)
+		if !labelKey.MatchString(req.Key) {
+			return nil, fmt.Errorf("invalid label selector %q", part)
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// matches reports whether labels meet every requirement of the selector; a missing label
+// does not equal any value
+func (s labelSelector) matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.Key]
+		met := ok
+		if !req.Exists {
+			met = ok && value == req.Value
+		}
+		if met == req.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// selectCounters returns the live counters whose name matches pattern and whose labels match selector
+func selectCounters(pattern, selector string) ([]indexEntry, error) {
+	parsed, parseErr := parseSelector(selector)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	entries, indexErr := nameIndex(func(name string) bool { return matchName(pattern, name) })
+	if indexErr != nil {
+		return nil, indexErr
+	}
+	selected := entries[:0]
+	for _, entry := range entries {
+		if parsed.matches(entry.Meta.Labels) {
+			selected = append(selected, entry)
+		}
+	}
+	return selected, nil
+}
+
+// runLabel shows and edits the labels of a counter
+func runLabel(args []string) error {
+	usage := errors.New("usage: counter label set <name> key=value... | remove <name> key... | show <name>")
+	if len(args) == 0 {
+		return usage
+	}
+	fs := newCommandFlags("label " + args[0])
+	positional, parseErr := parseCommandArgs(fs, args[1:])
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) == 0 {
+		return usage
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	name := positional[0]
+	path := counterPath(name)
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("counter %s does not exist", name)
+	}
+	switch args[0] {
+	case "set":
+		labels, labelErr := parseLabels(positional[1:])
+		if labelErr != nil {
+			return labelErr
+		}
+		if len(labels) == 0 {
+			return usage
+		}
+		editErr := editMeta(path, func(meta *counterMeta) error {
+			if meta.Labels == nil {
+				meta.Labels = make(map[string]string)
+			}
+			for key, value := range labels {
+				meta.Labels[key] = value
+			}
+			return nil
+		})
+		if editErr != nil {
+			return editErr
+		}
+	case "remove":
+		if len(positional) == 1 {
+			return usage
+		}
+		editErr := editMeta(path, func(meta *counterMeta) error {
+			for _, key := range positional[1:] {
+				delete(meta.Labels, key)
+			}
+			if len(meta.Labels) == 0 {
+				meta.Labels = nil
+			}
+			return nil
+		})
+		if editErr != nil {
+			return editErr
+		}
+	case "show":
+		if len(positional) != 1 {
+			return usage
+		}
+	default:
+		return usage
+	}
+	if err := recordName(path, name); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not record counter name: %v\n", err)
+	}
+	meta, metaErr := readMeta(path)
+	if metaErr != nil {
+		return metaErr
+	}
+	fmt.Println(formatLabels(meta.Labels))
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestParseLabels tests the parseLabels and formatLabels functions
+func TestParseLabels(t *testing.T) {
+	labels, err := parseLabels([]string{"owner=payments", "env=prod"})
+	if err != nil {
+		t.Fatalf("parseLabels failed: %v", err)
+	}
+	if got := formatLabels(labels); got != "env=prod,owner=payments" {
+		t.Errorf("Expected env=prod,owner=payments, got %q", got)
+	}
+	for _, arg := range []string{"env", "1env=prod", "name=x", "__meta=x", "env=a,b"} {
+		if _, err := parseLabels([]string{arg}); err == nil {
+			t.Errorf("Expected %q to be rejected", arg)
+		}
+	}
+}
+
+// TestLabelSelector tests the parseSelector function and labelSelector.matches
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"env": "prod", "owner": "payments"}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=prod,owner!=infra", true},
+		{"env=prod,owner!=payments", false},
+		{"env=dev", false},
+		{"team!=core", true},
+		{"team=", false},
+		{"owner", true},
+		{"!owner", false},
+		{"!team", true},
+	}
+	for _, tt := range tests {
+		selector, err := parseSelector(tt.selector)
+		if err != nil {
+			t.Fatalf("parseSelector(%q) failed: %v", tt.selector, err)
+		}
+		if got := selector.matches(labels); got != tt.want {
+			t.Errorf("Expected %q to match %v, got %v", tt.selector, tt.want, got)
+		}
+	}
+	if _, err := parseSelector("bad key=x"); err == nil {
+		t.Errorf("Expected an invalid key to be rejected")
+	}
+}
+
+// TestSelectCounters tests selecting counters by name pattern and labels
+func TestSelectCounters(t *testing.T) {
+	useCounterDir(t)
+	setCounters(t, map[string]int64{"api.hits": 4, "web.hits": 2, "api.errors": 1})
+	for name, labels := range map[string]map[string]string{
+		"api.hits":   {"env": "prod", "owner": "payments"},
+		"web.hits":   {"env": "prod", "owner": "infra"},
+		"api.errors": {"env": "dev"},
+	} {
+		err := editMeta(counterPath(name), func(meta *counterMeta) error {
+			meta.Labels = labels
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("editMeta failed: %v", err)
+		}
+	}
+	entries, err := selectCounters("*", "env=prod,owner!=infra")
+	if err != nil {
+		t.Fatalf("selectCounters failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != "api.hits" {
+		t.Errorf("Expected only api.hits, got %+v", entries)
+	}
+	if entries, _ = selectCounters("api.*", ""); len(entries) != 2 {
+		t.Errorf("Expected both api counters, got %+v", entries)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// counterMeta holds the settings stored alongside a counter
+type counterMeta struct {
+	Type      string            `json:"type,omitempty"`
+	Scale     int               `json:"scale,omitempty"`
+	Precision *int              `json:"precision,omitempty"`
+	Schedule  *resetSchedule    `json:"schedule,omitempty"`
+	Tombstone bool              `json:"tombstone,omitempty"`
+	Merge     string            `json:"merge,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Expr      string            `json:"expr,omitempty"`
+	Buckets   []float64         `json:"buckets,omitempty"`
+	HalfLife  string            `json:"half_life,omitempty"`
+	Unit      string            `json:"unit,omitempty"`
+}
+
+// metaPath returns the file that stores the metadata of a counter
+func metaPath(filePath string) string {
+	return sidecarPath(filePath, "meta")
+}
+
+// readMeta reads the metadata of a counter, returning empty metadata when none has been stored
+func readMeta(filePath string) (counterMeta, error) {
+	var meta counterMeta
+	data, err := os.ReadFile(metaPath(filePath))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return meta, nil
+		}
+		return meta, fmt.Errorf("failed to read counter metadata: %w", err)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("invalid counter metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// writeMeta stores the metadata of a counter; the caller must hold the counter lock
+func writeMeta(filePath string, meta counterMeta) error {
+	data, marshalErr := json.MarshalIndent(meta, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return writeFileAtomic(metaPath(filePath), data, 0600)
+}
+
+// editMeta applies fn to the metadata of a counter while holding the counter lock and stores the result
+func editMeta(filePath string, fn func(*counterMeta) error) error {
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return lockErr
+	}
+	defer unlock()
+	meta, readErr := readMeta(filePath)
+	if readErr != nil {
+		return readErr
+	}
+	if err := fn(&meta); err != nil {
+		return err
+	}
+	return writeMeta(filePath, meta)
+}
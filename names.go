@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const NamesFile string = ".names.json"
+
+// loadNames reads the manifest in dir that maps counter file names back to counter names
+func loadNames(dir string) (map[string]string, error) {
+	names := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(dir, NamesFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return names, nil
+		}
+		return nil, fmt.Errorf("failed to read names manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("invalid names manifest: %w", err)
+	}
+	return names, nil
+}
+
+// recordName adds the counter stored in filePath under name to the manifest of its directory
+func recordName(filePath, name string) error {
+	dir, file := filepath.Split(filePath)
+	manifest := filepath.Join(dir, NamesFile)
+	unlock, lockErr := lockFile(manifest + ".lock")
+	if lockErr != nil {
+		return lockErr
+	}
+	defer unlock()
+	names, loadErr := loadNames(dir)
+	if loadErr != nil {
+		return loadErr
+	}
+	if names[file] == name {
+		return nil
+	}
+	names[file] = name
+	data, marshalErr := json.MarshalIndent(names, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return writeFileAtomic(manifest, data, 0600)
+}
+
+// nameOf returns the counter name stored in file, falling back to the file name for counters
+// that were not created with -name; hidden files without a manifest entry are not counters
+func nameOf(file string, names map[string]string) (string, bool) {
+	if name, ok := names[file]; ok {
+		return name, true
+	}
+	if strings.HasPrefix(file, ".") {
+		return "", false
+	}
+	return file, true
+}
+
+// sidecarOwner returns the name of the counter that a hidden sidecar file of the given kind belongs to
+func sidecarOwner(file, kind string, names map[string]string) (string, bool) {
+	suffix := "." + kind
+	if !strings.HasPrefix(file, ".") || !strings.HasSuffix(file, suffix) {
+		return "", false
+	}
+	base := strings.TrimSuffix(file, suffix)
+	if name, ok := names[base]; ok {
+		return name, true
+	}
+	return nameOf(strings.TrimPrefix(base, "."), names)
+}
+
+// matchName reports whether a counter name matches a shell pattern where * also matches dots
+func matchName(pattern, name string) bool {
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
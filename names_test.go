@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestRecordName tests the recordName and loadNames functions
+func TestRecordName(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, generateCounterFileName("jobs.done"))
+	if err := recordName(testFile, "jobs.done"); err != nil {
+		t.Fatalf("Failed to record name: %v", err)
+	}
+	if err := recordName(testFile, "jobs.done"); err != nil {
+		t.Fatalf("Failed to record name twice: %v", err)
+	}
+	names, err := loadNames(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to load names: %v", err)
+	}
+	if len(names) != 1 || names[filepath.Base(testFile)] != "jobs.done" {
+		t.Errorf("Expected a single entry for jobs.done, got %v", names)
+	}
+}
+
+// TestNameOf tests the nameOf function
+func TestNameOf(t *testing.T) {
+	names := map[string]string{".named.abc.counter": "jobs.done"}
+	if name, ok := nameOf(".named.abc.counter", names); !ok || name != "jobs.done" {
+		t.Errorf("Expected jobs.done, got %q (%v)", name, ok)
+	}
+	if name, ok := nameOf("legacy", names); !ok || name != "legacy" {
+		t.Errorf("Expected legacy, got %q (%v)", name, ok)
+	}
+	if _, ok := nameOf(NamesFile, names); ok {
+		t.Errorf("Expected hidden files outside the manifest to be ignored")
+	}
+}
+
+// TestMatchName tests the matchName function
+func TestMatchName(t *testing.T) {
+	if !matchName("jobs.*", "jobs.build.done") {
+		t.Errorf("Expected jobs.* to match nested names")
+	}
+	if matchName("jobs.*", "tasks.done") {
+		t.Errorf("Expected jobs.* not to match tasks.done")
+	}
+	if matchName("[", "jobs") {
+		t.Errorf("Expected an invalid pattern not to match")
+	}
+}
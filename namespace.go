@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// indexEntry is a live counter in the name index of the counter directory
+type indexEntry struct {
+	Name  string
+	Path  string
+	Meta  counterMeta
+	Value string
+}
+
+// nameIndex lists the live counters of the counter directory whose name satisfies keep, sorted by name;
+// counters that were deleted and only left a tombstone behind are not listed, and counters that cannot be
+// read are reported and skipped so one bad counter does not hide the rest
+func nameIndex(keep func(name string) bool) ([]indexEntry, error) {
+	counters, listErr := dirCounters(counterDir)
+	if listErr != nil {
+		return nil, listErr
+	}
+	var entries []indexEntry
+	for _, counter := range counters {
+		if !keep(counter.Name) {
+			continue
+		}
+		filePath := filepath.Join(counterDir, counter.File)
+		if _, err := os.Stat(filePath); err != nil {
+			continue
+		}
+		meta, metaErr := readMeta(filePath)
+		if metaErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: Skipping %s: %v\n", counter.Name, metaErr)
+			continue
+		}
+		value, readErr := readTyped(filePath)
+		if readErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: Skipping %s: %v\n", counter.Name, readErr)
+			continue
+		}
+		entries = append(entries, indexEntry{Name: counter.Name, Path: filePath, Meta: meta, Value: value})
+	}
+	return entries, nil
+}
+
+// inNamespace reports whether name is the namespace itself or a name nested under it
+func inNamespace(namespace, name string) bool {
+	return namespace == "" || name == namespace || strings.HasPrefix(name, namespace+".")
+}
+
+// sumEntries adds up the values of counters of any type; the total is exact unless a float counter is
+// involved and keeps as many decimal places as the most precise decimal counter
+func sumEntries(entries []indexEntry) (string, error) {
+	total := new(big.Rat)
+	scale, floats := 0, false
+	for _, entry := range entries {
+		value, err := parseNumber(entry.Value)
+		if err != nil {
+			return "", fmt.Errorf("counter %s: %w", entry.Name, err)
+		}
+		total.Add(total, value)
+		switch entry.Meta.Type {
+		case TypeFloat, TypeDecay:
+			floats = true
+		case TypeDecimal:
+			scale = max(scale, entry.Meta.scale())
+		}
+	}
+	if floats {
+		f, _ := total.Float64()
+		return formatFloat(f), nil
+	}
+	return total.FloatString(scale), nil
+}
+
+// runSum prints the total of every counter whose name matches a pattern
+func runSum(args []string) error {
+	fs := newCommandFlags("sum")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: counter sum <pattern>")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	pattern := positional[0]
+	entries, indexErr := nameIndex(func(name string) bool { return matchName(pattern, name) })
+	if indexErr != nil {
+		return indexErr
+	}
+	total, sumErr := sumEntries(entries)
+	if sumErr != nil {
+		return sumErr
+	}
+	fmt.Println(total)
+	return nil
+}
+
+// namespaceNode is a segment of a dotted counter name along with the counters nested under it
+type namespaceNode struct {
+	Segment  string
+	Entries  []indexEntry
+	Children map[string]*namespaceNode
+}
+
+// child returns the node for segment under n, creating it when needed
+func (n *namespaceNode) child(segment string) *namespaceNode {
+	if n.Children == nil {
+		n.Children = make(map[string]*namespaceNode)
+	}
+	node, ok := n.Children[segment]
+	if !ok {
+		node = &namespaceNode{Segment: segment}
+		n.Children[segment] = node
+	}
+	return node
+}
+
+// print writes the node and its children indented by depth, each followed by its rollup total
+func (n *namespaceNode) print(depth int) error {
+	total, err := sumEntries(n.Entries)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s%s %s\n", strings.Repeat("  ", depth), n.Segment, total)
+	segments := make([]string, 0, len(n.Children))
+	for segment := range n.Children {
+		segments = append(segments, segment)
+	}
+	sort.Strings(segments)
+	for _, segment := range segments {
+		if err := n.Children[segment].print(depth + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runTree prints the counters of a namespace as a tree of dotted name segments with rollup totals
+func runTree(args []string) error {
+	fs := newCommandFlags("tree")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) > 1 {
+		return errors.New("usage: counter tree [namespace]")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	namespace := ""
+	if len(positional) == 1 {
+		namespace = strings.TrimSuffix(positional[0], ".")
+	}
+	entries, indexErr := nameIndex(func(name string) bool { return inNamespace(namespace, name) })
+	if indexErr != nil {
+		return indexErr
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no counters in namespace %s", namespace)
+	}
+
+	root := &namespaceNode{}
+	for _, entry := range entries {
+		node := root
+		// the namespace itself is a single node, even when it contains dots
+		rest := entry.Name
+		if namespace != "" {
+			node = node.child(namespace)
+			rest = strings.TrimPrefix(strings.TrimPrefix(entry.Name, namespace), ".")
+		}
+		node.Entries = append(node.Entries, entry)
+		for _, segment := range strings.Split(rest, ".") {
+			if segment == "" {
+				continue
+			}
+			node = node.child(segment)
+			node.Entries = append(node.Entries, entry)
+		}
+	}
+	segments := make([]string, 0, len(root.Children))
+	for segment := range root.Children {
+		segments = append(segments, segment)
+	}
+	sort.Strings(segments)
+	for _, segment := range segments {
+		if err := root.Children[segment].print(0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetEntry sets a counter of any type back to 0
+func resetEntry(entry indexEntry) error {
+	if entry.Meta.typed() {
+		_, err := editTyped(entry.Name, func(meta counterMeta, current string) (string, error) {
+			return meta.normalize("0")
+		})
+		return err
+	}
+	_, _, err := updateCounter(entry.Name, func(current int64) (int64, error) {
+		return 0, nil
+	})
+	return err
+}
+
+// runReset sets every counter whose name matches a pattern back to 0 once confirmed with -yes
+func runReset(args []string) error {
+	fs := newCommandFlags("reset")
+	fs.BoolVar(&useYes, "yes", useYes, "your response is yes")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: counter reset <pattern> [-yes]")
+	}
+	if neverReset {
+		return errors.New("reset operation is disabled by the environment variable")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	pattern := positional[0]
+	entries, indexErr := nameIndex(func(name string) bool { return matchName(pattern, name) })
+	if indexErr != nil {
+		return indexErr
+	}
+	// derived counters follow the counters they are computed from
+	entries = slices.DeleteFunc(entries, func(entry indexEntry) bool { return entry.Meta.Type == TypeDerived })
+	if len(entries) == 0 {
+		return fmt.Errorf("no counters match %s", pattern)
+	}
+	if !useYes {
+		for _, entry := range entries {
+			_, _ = fmt.Fprintf(os.Stderr, "  %s (%s)\n", entry.Name, entry.Value)
+		}
+		return fmt.Errorf("will reset %d counters matching %s to 0 after you re-run with -yes", len(entries), pattern)
+	}
+	for _, entry := range entries {
+		if err := resetEntry(entry); err != nil {
+			return fmt.Errorf("counter %s: %w", entry.Name, err)
+		}
+		fmt.Printf("counter %s reset\n", entry.Name)
+	}
+	return nil
+}
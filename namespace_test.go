@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+)
+
+// setCounters stores int values under the given counter names
+func setCounters(t *testing.T, values map[string]int64) {
+	t.Helper()
+	for name, value := range values {
+		if _, _, err := updateCounter(name, func(int64) (int64, error) { return value, nil }); err != nil {
+			t.Fatalf("failed to set %s: %v", name, err)
+		}
+	}
+}
+
+// TestNameIndex tests the nameIndex and inNamespace functions
+func TestNameIndex(t *testing.T) {
+	useCounterDir(t)
+	setCounters(t, map[string]int64{"team.api.requests.2xx": 30, "team.api.requests.5xx": 2, "teams": 7})
+	entries, err := nameIndex(func(name string) bool { return inNamespace("team", name) })
+	if err != nil {
+		t.Fatalf("nameIndex failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name != "team.api.requests.2xx" || entries[1].Value != "2" {
+		t.Errorf("Expected the two counters of namespace team, got %+v", entries)
+	}
+
+	path := counterPath("teams")
+	if err := writeMeta(path, counterMeta{Schedule: &resetSchedule{Cron: "0 0 30 2 *"}}); err != nil {
+		t.Fatalf("writeMeta failed: %v", err)
+	}
+	entries, err = nameIndex(func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("Expected a counter that cannot be read to be skipped, got %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected the two readable counters, got %+v", entries)
+	}
+}
+
+// TestSumEntries tests sums over int, decimal and float counters
+func TestSumEntries(t *testing.T) {
+	useCounterDir(t)
+	setCounters(t, map[string]int64{"shop.orders": 3, "shop.refunds": -1})
+	all := func(string) bool { return true }
+	entries, _ := nameIndex(all)
+	if total, err := sumEntries(entries); err != nil || total != "2" {
+		t.Errorf("Expected 2, got %q (%v)", total, err)
+	}
+
+	if _, err := convertType(counterPath("shop.cash"), counterMeta{Type: TypeDecimal, Scale: 2}); err != nil {
+		t.Fatalf("convertType failed: %v", err)
+	}
+	if _, err := addTyped("shop.cash", "0.5"); err != nil {
+		t.Fatalf("addTyped failed: %v", err)
+	}
+	entries, _ = nameIndex(all)
+	if total, err := sumEntries(entries); err != nil || total != "2.50" {
+		t.Errorf("Expected 2.50, got %q (%v)", total, err)
+	}
+
+	if _, err := convertType(counterPath("shop.load"), counterMeta{Type: TypeFloat}); err != nil {
+		t.Fatalf("convertType failed: %v", err)
+	}
+	if _, err := addTyped("shop.load", "0.25"); err != nil {
+		t.Fatalf("addTyped failed: %v", err)
+	}
+	entries, _ = nameIndex(all)
+	if total, err := sumEntries(entries); err != nil || total != "2.75" {
+		t.Errorf("Expected 2.75, got %q (%v)", total, err)
+	}
+}
+
+// TestResetEntry tests resetting int and typed counters found in the name index
+func TestResetEntry(t *testing.T) {
+	useCounterDir(t)
+	setCounters(t, map[string]int64{"team.api.hits": 5})
+	if _, err := convertType(counterPath("team.api.cash"), counterMeta{Type: TypeDecimal, Scale: 2}); err != nil {
+		t.Fatalf("convertType failed: %v", err)
+	}
+	if _, err := addTyped("team.api.cash", "1.25"); err != nil {
+		t.Fatalf("addTyped failed: %v", err)
+	}
+	entries, _ := nameIndex(func(name string) bool { return matchName("team.api.*", name) })
+	for _, entry := range entries {
+		if err := resetEntry(entry); err != nil {
+			t.Fatalf("resetEntry failed for %s: %v", entry.Name, err)
+		}
+	}
+	for _, name := range []string{"team.api.hits", "team.api.cash"} {
+		if value, err := readTyped(counterPath(name)); err != nil || (value != "0" && value != "0.00") {
+			t.Errorf("Expected %s to be reset, got %q (%v)", name, value, err)
+		}
+	}
+}
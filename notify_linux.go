@@ -0,0 +1,75 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// dirWatcher reports the names of files that change inside a directory using inotify
+type dirWatcher struct {
+	file   *os.File
+	Events chan string
+	done   chan struct{}
+}
+
+// newDirWatcher starts watching dir for files that are written, renamed into place or removed
+func newDirWatcher(dir string) (*dirWatcher, error) {
+	fd, initErr := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if initErr != nil {
+		return nil, os.NewSyscallError("inotify_init1", initErr)
+	}
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM | syscall.IN_DELETE)
+	if _, watchErr := syscall.InotifyAddWatch(fd, dir, mask); watchErr != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", watchErr)
+	}
+	w := &dirWatcher{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		Events: make(chan string),
+		done:   make(chan struct{}),
+	}
+	go w.read()
+	return w, nil
+}
+
+// read decodes inotify events and forwards the file names until the watcher is closed
+func (w *dirWatcher) read() {
+	defer close(w.Events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			end := start + int(event.Len)
+			offset = end
+			if event.Len == 0 || end > n {
+				continue
+			}
+			name := string(bytes.TrimRight(buf[start:end], "\x00"))
+			select {
+			case w.Events <- name:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// Close stops the watcher and releases the inotify descriptor
+func (w *dirWatcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+		close(w.done)
+	}
+	return w.file.Close()
+}
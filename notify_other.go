@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// errNotifyUnsupported is returned where filesystem notifications are not implemented
+var errNotifyUnsupported = errors.New("filesystem notifications are not supported on this platform")
+
+// dirWatcher is a placeholder on platforms without inotify; callers fall back to polling
+type dirWatcher struct {
+	Events chan string
+}
+
+// newDirWatcher always fails so that callers poll the directory instead
+func newDirWatcher(dir string) (*dirWatcher, error) {
+	return nil, errNotifyUnsupported
+}
+
+// Close does nothing on platforms without inotify
+func (w *dirWatcher) Close() error {
+	return nil
+}
@@ -0,0 +1,692 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	TypeInt               string = "int"
+	TypeBig               string = "big"
+	TypeDecimal           string = "decimal"
+	TypeFloat             string = "float"
+	TypeDerived           string = "derived"
+	DefaultDecimalScale   int    = 2
+	DefaultFloatPrecision int    = -1
+)
+
+var (
+	quantityText string
+	setToText    string
+)
+
+// numberFlag is an int64 flag that also keeps the text it was given, so that big, decimal and float
+// counters can accept values such as 12.50 or 1e30 that do not fit an int64
+type numberFlag struct {
+	value *int64
+	text  *string
+}
+
+// String returns the text of the flag
+func (f numberFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	if f.text != nil && *f.text != "" {
+		return *f.text
+	}
+	return strconv.FormatInt(*f.value, 10)
+}
+
+// Set stores s, keeping the int64 when s is an integer in range
+func (f numberFlag) Set(s string) error {
+	if _, err := parseNumber(s); err != nil {
+		return err
+	}
+	*f.text = s
+	if value, err := strconv.ParseInt(s, 10, 64); err == nil {
+		*f.value = value
+	}
+	return nil
+}
+
+// typed reports whether the counter holds a big, decimal or float value instead of an int64
+func (m counterMeta) typed() bool {
+	return m.Type != "" && m.Type != TypeInt
+}
+
+// scale returns the number of decimal places the counter keeps
+func (m counterMeta) scale() int {
+	if m.Type == TypeDecimal {
+		return m.Scale
+	}
+	return 0
+}
+
+// typeName describes the type of the counter
+func (m counterMeta) typeName() string {
+	switch m.Type {
+	case "", TypeInt:
+		return TypeInt
+	case TypeDecimal:
+		return fmt.Sprintf("%s (scale %d)", TypeDecimal, m.Scale)
+	case TypeFloat:
+		if m.Precision != nil {
+			return fmt.Sprintf("%s (precision %d)", TypeFloat, *m.Precision)
+		}
+	case TypeDerived:
+		return fmt.Sprintf("%s (%s)", TypeDerived, m.Expr)
+	case TypeDecay:
+		if m.HalfLife == "" {
+			return fmt.Sprintf("%s (half-life %s)", TypeDecay, DefaultHalfLife)
+		}
+		return fmt.Sprintf("%s (half-life %s)", TypeDecay, m.HalfLife)
+	}
+	return m.Type
+}
+
+// requireInteger fails for counters whose type is not int, which only the typed operations understand
+func requireInteger(filePath string) error {
+	meta, err := readMeta(filePath)
+	if err != nil {
+		return err
+	}
+	if err := requireWritable(meta); err != nil {
+		return err
+	}
+	if meta.typed() {
+		return fmt.Errorf("counter is a %s counter; use -add, -sub, -set or get", meta.typeName())
+	}
+	return nil
+}
+
+// requireIntegerFlags rejects -q and -set values that only typed counters accept
+func requireIntegerFlags() error {
+	for _, text := range []string{quantityText, setToText} {
+		if text == "" {
+			continue
+		}
+		if _, err := strconv.ParseInt(text, 10, 64); err != nil {
+			return fmt.Errorf("%s is not an int64; change the type of the counter with counter type", text)
+		}
+	}
+	return nil
+}
+
+// parseNumber parses text as an exact decimal number such as 42, -12.50 or 1e30
+func parseNumber(text string) (*big.Rat, error) {
+	text = strings.TrimSpace(text)
+	if strings.Contains(text, "/") {
+		return nil, fmt.Errorf("invalid number %q", text)
+	}
+	r, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", text)
+	}
+	return r, nil
+}
+
+// parseScaled parses text as an exact decimal number and returns it multiplied by 10^scale
+func parseScaled(text string, scale int) (*big.Int, error) {
+	if strings.TrimSpace(text) == "" {
+		return new(big.Int), nil
+	}
+	r, err := parseNumber(text)
+	if err != nil {
+		return nil, err
+	}
+	r.Mul(r, new(big.Rat).SetInt(pow10(scale)))
+	if !r.IsInt() {
+		return nil, fmt.Errorf("%s has more than %d decimal places", strings.TrimSpace(text), scale)
+	}
+	return new(big.Int).Set(r.Num()), nil
+}
+
+// formatScaled formats a value that was multiplied by 10^scale with exactly scale decimal places
+func formatScaled(value *big.Int, scale int) string {
+	digits := new(big.Int).Abs(value).String()
+	if scale > 0 {
+		if len(digits) <= scale {
+			digits = strings.Repeat("0", scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	}
+	if value.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// pow10 returns 10^n
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// clampScaled returns the integer part of a scaled value clamped to the int64 range, which is what
+// hooks and webhooks see for big and decimal counters
+func clampScaled(value *big.Int, scale int) int64 {
+	whole := new(big.Int).Quo(value, pow10(scale))
+	if whole.IsInt64() {
+		return whole.Int64()
+	}
+	if whole.Sign() < 0 {
+		return math.MinInt64
+	}
+	return math.MaxInt64
+}
+
+// clampFloat returns the integer part of a float clamped to the int64 range
+func clampFloat(value float64) int64 {
+	switch {
+	case value >= math.MaxInt64:
+		return math.MaxInt64
+	case value <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int64(value)
+}
+
+// parseFloat parses a float gauge value, rejecting NaN and values outside the float64 range
+func parseFloat(text string) (float64, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid float %q: NaN and infinite values are not allowed", text)
+	}
+	return value, nil
+}
+
+// formatFloat formats a float gauge in the shortest form that reads back as the same value
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// normalize parses text as a value of the counter's type and returns the canonical text it is stored as
+func (m counterMeta) normalize(text string) (string, error) {
+	if m.Type == TypeUnique {
+		sketch, err := parseSketch(text)
+		if err != nil {
+			return "", err
+		}
+		return sketch.String(), nil
+	}
+	if m.Type == TypeHistogram {
+		h, err := parseHistogram(text, m.histogramBuckets())
+		if err != nil {
+			return "", err
+		}
+		return h.String(), nil
+	}
+	if m.Type == TypeDecay {
+		d, err := m.currentDecay(text)
+		if err != nil {
+			return "", err
+		}
+		return d.String(), nil
+	}
+	if m.Type == TypeFloat {
+		value, err := parseFloat(text)
+		if err != nil {
+			return "", err
+		}
+		return formatFloat(value), nil
+	}
+	value, err := parseScaled(text, m.scale())
+	if err != nil {
+		return "", err
+	}
+	if !m.typed() && !value.IsInt64() {
+		return "", fmt.Errorf("%s does not fit an int64", value)
+	}
+	return formatScaled(value, m.scale()), nil
+}
+
+// combine adds operand to current, or subtracts it when negate is set, in the arithmetic of the counter's type
+func (m counterMeta) combine(current, operand string, negate bool) (string, error) {
+	if m.Type == TypeUnique {
+		return "", errors.New("add items to a unique counter with counter uadd")
+	}
+	if m.Type == TypeHistogram {
+		return "", errors.New("add observations to a histogram counter with counter observe")
+	}
+	if m.Type == TypeDecay {
+		return m.addDecay(current, operand, negate)
+	}
+	if m.Type == TypeFloat {
+		a, aErr := parseFloat(current)
+		if aErr != nil {
+			return "", aErr
+		}
+		b, bErr := parseFloat(operand)
+		if bErr != nil {
+			return "", bErr
+		}
+		if negate {
+			b = -b
+		}
+		if math.IsInf(a+b, 0) {
+			return "", errors.New("result does not fit a float64")
+		}
+		return formatFloat(a + b), nil
+	}
+	a, aErr := parseScaled(current, m.scale())
+	if aErr != nil {
+		return "", aErr
+	}
+	b, bErr := parseScaled(operand, m.scale())
+	if bErr != nil {
+		return "", bErr
+	}
+	if negate {
+		b.Neg(b)
+	}
+	return formatScaled(a.Add(a, b), m.scale()), nil
+}
+
+// display formats a stored value for output, rounding float gauges to the precision of the counter
+func (m counterMeta) display(stored string) string {
+	if m.Type == TypeUnique {
+		sketch, err := parseSketch(stored)
+		if err != nil {
+			return "0"
+		}
+		return strconv.FormatUint(sketch.estimate(), 10)
+	}
+	if m.Type == TypeHistogram {
+		h, err := parseHistogram(stored, m.histogramBuckets())
+		if err != nil {
+			return "0"
+		}
+		return strconv.FormatUint(h.Count, 10)
+	}
+	if m.Type == TypeDecay {
+		d, err := m.currentDecay(stored)
+		if err != nil {
+			return "0"
+		}
+		if m.Precision != nil {
+			return strconv.FormatFloat(d.Value, 'f', *m.Precision, 64)
+		}
+		// decayed values are rounded to thousandths unless the counter has a precision
+		return formatFloat(math.Round(d.Value*1000) / 1000)
+	}
+	if m.Type == TypeFloat && m.Precision != nil {
+		if value, err := parseFloat(stored); err == nil {
+			return strconv.FormatFloat(value, 'f', *m.Precision, 64)
+		}
+	}
+	return stored
+}
+
+// wholeValue returns the integer part of a stored value clamped to the int64 range, which is what
+// hooks and webhooks see for typed counters
+func (m counterMeta) wholeValue(stored string) int64 {
+	if m.Type == TypeUnique {
+		sketch, _ := parseSketch(stored)
+		return clampEstimate(sketch.estimate())
+	}
+	if m.Type == TypeHistogram {
+		h, _ := parseHistogram(stored, m.histogramBuckets())
+		return clampEstimate(h.Count)
+	}
+	if m.Type == TypeDecay {
+		d, _ := m.currentDecay(stored)
+		return clampFloat(d.Value)
+	}
+	if m.Type == TypeFloat {
+		value, _ := parseFloat(stored)
+		return clampFloat(value)
+	}
+	value, err := parseScaled(stored, m.scale())
+	if err != nil {
+		return 0
+	}
+	return clampScaled(value, m.scale())
+}
+
+// readStored reads a typed counter and returns its value in canonical form; derived counters are evaluated
+func readStored(filePath string, meta counterMeta) (string, error) {
+	if meta.Type == TypeDerived {
+		value, err := evalDerived(meta.Expr)
+		return strconv.FormatInt(value, 10), err
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read counter file: %w", err)
+	}
+	value, parseErr := meta.normalize(string(data))
+	if parseErr != nil {
+		return "", fmt.Errorf("invalid counter value: %w", parseErr)
+	}
+	return value, nil
+}
+
+// peekValue reads a counter of any type without taking its lock and formats its value for output
+func peekValue(filePath string) (string, error) {
+	meta, metaErr := readMeta(filePath)
+	if metaErr != nil {
+		return "", metaErr
+	}
+	if !meta.typed() {
+		value, err := readCounter(filePath)
+		return strconv.FormatInt(value, 10), err
+	}
+	value, err := readStored(filePath, meta)
+	return meta.display(value), err
+}
+
+// applyTypedFlags applies -reset, -set, -add and -sub to a typed value in the same order of precedence
+// as for int64 counters; changed is false when no flag asks for a change
+func applyTypedFlags(current string, meta counterMeta) (next string, changed bool, err error) {
+	if updateExpr != DefaultUpdateExpr {
+		return "", false, fmt.Errorf("-expr needs an int counter, not a %s counter", meta.typeName())
+	}
+	if doReset {
+		if neverReset {
+			return "", false, errors.New("reset operation is disabled by the environment variable")
+		}
+		if !useYes {
+			return "", false, errors.New("will reset the counter to 0 after you re-run with -yes")
+		}
+		next, err = meta.normalize("0")
+		return next, err == nil, err
+	}
+	amountText := quantityText
+	if amountText == "" {
+		amountText = strconv.FormatInt(quantity, 10)
+	}
+	switch {
+	case setToText != "" && !neverSetTo:
+		next, err = meta.normalize(setToText)
+	case doAdd && !neverAdd:
+		next, err = meta.combine(current, amountText, false)
+	case doSub && !neverSubtract:
+		next, err = meta.combine(current, amountText, true)
+	default:
+		return current, false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return next, true, nil
+}
+
+// runTypedFlags applies the counter flags to a typed counter whose lock is held, prints the value and exits
+func runTypedFlags(filePath, name string, meta counterMeta, unlock func()) {
+	current, readErr := readStored(filePath, meta)
+	if readErr != nil {
+		unlock()
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", readErr)
+		os.Exit(1)
+	}
+	next, changed, applyErr := applyTypedFlags(current, meta)
+	if applyErr != nil {
+		unlock()
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", applyErr)
+		os.Exit(1)
+	}
+	if !changed {
+		unlock()
+		printValue(name, meta.display(current), meta)
+		os.Exit(0)
+	}
+	if err := requireWritable(meta); err != nil {
+		unlock()
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	// the new value is formatted before it is stored, so that a -format that does not fit it leaves the counter as it was
+	output, formatErr := printFormat.apply(name, meta.display(next), meta)
+	if formatErr != nil {
+		unlock()
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", formatErr)
+		os.Exit(1)
+	}
+	if err := storeCounterText(filePath, next); err != nil {
+		unlock()
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if counterName != DefaultCounterName {
+		if err := recordName(filePath, counterName); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not record counter name: %v\n", err)
+		}
+	}
+	unlock()
+	afterMutation(counterDir, name, filePath, meta.wholeValue(current), meta.wholeValue(next))
+	fmt.Println(output)
+	os.Exit(0)
+}
+
+// addTyped adds the number in text to a typed counter and returns the new value formatted for output
+func addTyped(name, text string) (string, error) {
+	return editTyped(name, func(meta counterMeta, current string) (string, error) {
+		return meta.combine(current, text, false)
+	})
+}
+
+// editTyped applies fn to the stored value of a typed counter while holding its lock, stores the result and
+// returns it formatted for output
+func editTyped(name string, fn func(meta counterMeta, current string) (string, error)) (string, error) {
+	filePath := counterPath(name)
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return "", lockErr
+	}
+	meta, metaErr := readMeta(filePath)
+	if metaErr == nil {
+		metaErr = requireWritable(meta)
+	}
+	if metaErr != nil {
+		unlock()
+		return "", metaErr
+	}
+	current, readErr := readStored(filePath, meta)
+	if readErr != nil {
+		unlock()
+		return "", readErr
+	}
+	next, fnErr := fn(meta, current)
+	if fnErr != nil {
+		unlock()
+		return "", fnErr
+	}
+	if err := storeCounterText(filePath, next); err != nil {
+		unlock()
+		return "", err
+	}
+	if err := recordName(filePath, name); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not record counter name: %v\n", err)
+	}
+	unlock()
+	afterMutation(counterDir, name, filePath, meta.wholeValue(current), meta.wholeValue(next))
+	return meta.display(next), nil
+}
+
+// readTyped reads a counter of any type under its lock and formats its value for output
+func readTyped(filePath string) (string, error) {
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return "", lockErr
+	}
+	defer unlock()
+	meta, metaErr := readMeta(filePath)
+	if metaErr != nil {
+		return "", metaErr
+	}
+	if !meta.typed() {
+		value, readErr := readCounter(filePath)
+		if readErr != nil {
+			return "", readErr
+		}
+		value, readErr = applySchedule(filePath, value)
+		return strconv.FormatInt(value, 10), readErr
+	}
+	value, readErr := readStored(filePath, meta)
+	return meta.display(value), readErr
+}
+
+// convertType changes the type of a counter, converting its value exactly or failing when the value
+// does not fit the new type, such as 12.50 as an int or 1e30 as an int64
+func convertType(filePath string, target counterMeta) (string, error) {
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return "", lockErr
+	}
+	defer unlock()
+	meta, metaErr := readMeta(filePath)
+	if metaErr == nil {
+		metaErr = requireWritable(meta)
+	}
+	if metaErr != nil {
+		return "", metaErr
+	}
+	if target.typed() && meta.Schedule != nil {
+		return "", errors.New("counters with a reset schedule must stay int")
+	}
+	data, readErr := os.ReadFile(filePath)
+	if readErr != nil && !os.IsNotExist(readErr) {
+		return "", fmt.Errorf("failed to read counter file: %w", readErr)
+	}
+	if !meta.typed() {
+		// int counters hold the per-node totals of a PN-counter
+		value, err := readCounter(filePath)
+		if err != nil {
+			return "", err
+		}
+		data = []byte(strconv.FormatInt(value, 10))
+	}
+	if (meta.Type == TypeUnique || meta.Type == TypeHistogram) && target.Type != meta.Type {
+		// unique and histogram counters convert to their estimate or count, since the values themselves are not kept
+		data = []byte(meta.display(string(data)))
+	}
+	if meta.Type == TypeDecay {
+		// decay counters convert to their value at the current time, decayed with their own half-life
+		d, err := meta.currentDecay(string(data))
+		if err != nil {
+			return "", err
+		}
+		data = []byte(formatFloat(d.Value))
+		if target.Type == TypeDecay {
+			data = []byte(d.String())
+		}
+	}
+	if target.Type == TypeHistogram && meta.Type != TypeHistogram && len(data) > 0 {
+		// other counters carry their value over as the number of observations
+		h, err := histogramOfCount(string(data), target.histogramBuckets())
+		if err != nil {
+			return "", fmt.Errorf("cannot convert to %s: %w", target.typeName(), err)
+		}
+		data = []byte(h.String())
+	}
+	text, convertErr := target.normalize(string(data))
+	if convertErr != nil {
+		return "", fmt.Errorf("cannot convert to %s: %w", target.typeName(), convertErr)
+	}
+	if readErr == nil {
+		if err := storeCounterText(filePath, text); err != nil {
+			return "", err
+		}
+	}
+	meta.Type, meta.Scale, meta.Precision, meta.Buckets = target.Type, target.Scale, target.Precision, target.Buckets
+	meta.HalfLife = target.HalfLife
+	if !meta.typed() {
+		meta.Type = ""
+	}
+	if err := writeMeta(filePath, meta); err != nil {
+		return "", err
+	}
+	return target.display(text), nil
+}
+
+// runType shows the type of a counter or converts it to another type
+func runType(args []string) error {
+	var (
+		scale       = DefaultDecimalScale
+		precision   = DefaultFloatPrecision
+		buckets     string
+		exponential string
+		halfLife    = DefaultHalfLife
+	)
+	fs := newCommandFlags("type")
+	fs.IntVar(&scale, "scale", scale, "decimal places kept by a decimal counter")
+	fs.IntVar(&precision, "precision", precision, "decimal places a float counter is printed with (-1 prints every digit)")
+	fs.StringVar(&buckets, "buckets", "", "bucket bounds of a histogram counter, such as 1,5,10,30,60")
+	fs.StringVar(&exponential, "exponential", "", "exponential buckets of a histogram counter as start,factor,count")
+	fs.StringVar(&halfLife, "half-life", halfLife, "time a decay counter takes to lose half of its value, such as 30m or 168h")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		return errors.New("usage: counter type <name> [int|big|decimal|float|unique|histogram|decay] [-scale N] [-precision N] [-buckets B | -exponential S,F,N] [-half-life D]")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	name := positional[0]
+	path := counterPath(name)
+	if len(positional) == 1 {
+		meta, err := readMeta(path)
+		if err != nil {
+			return err
+		}
+		fmt.Println(meta.typeName())
+		return nil
+	}
+	target := counterMeta{Type: positional[1]}
+	switch target.Type {
+	case TypeInt, TypeBig, TypeUnique:
+	case TypeHistogram:
+		var bucketErr error
+		switch {
+		case buckets != "" && exponential != "":
+			return errors.New("-buckets and -exponential cannot be combined")
+		case buckets != "":
+			target.Buckets, bucketErr = parseBuckets(buckets)
+		case exponential != "":
+			target.Buckets, bucketErr = parseExponential(exponential)
+		}
+		if bucketErr != nil {
+			return bucketErr
+		}
+	case TypeDecimal:
+		if scale < 0 || scale > 38 {
+			return errors.New("-scale must be between 0 and 38")
+		}
+		target.Scale = scale
+	case TypeDecay:
+		if _, err := parseHalfLife(halfLife); err != nil {
+			return err
+		}
+		target.HalfLife = halfLife
+		fallthrough
+	case TypeFloat:
+		if precision < -1 || precision > 17 {
+			return errors.New("-precision must be between -1 and 17")
+		}
+		if precision >= 0 {
+			target.Precision = &precision
+		}
+	default:
+		return fmt.Errorf("invalid type %q: expected int, big, decimal, float, unique, histogram or decay", target.Type)
+	}
+	value, convertErr := convertType(path, target)
+	if convertErr != nil {
+		return fmt.Errorf("counter %s: %w", name, convertErr)
+	}
+	if err := recordName(path, name); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not record counter name: %v\n", err)
+	}
+	fmt.Printf("counter %s is now %s: %s\n", name, target.typeName(), value)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+// TestParseScaled tests exact parsing and formatting of scaled decimal values
+func TestParseScaled(t *testing.T) {
+	tests := []struct {
+		text     string
+		scale    int
+		expected string
+	}{
+		{"12.5", 2, "12.50"},
+		{"-0.05", 2, "-0.05"},
+		{"7", 3, "7.000"},
+		{"12.00", 0, "12"},
+		{"1e20", 0, "100000000000000000000"},
+		{"", 2, "0.00"},
+	}
+	for _, test := range tests {
+		value, err := parseScaled(test.text, test.scale)
+		if err != nil {
+			t.Errorf("parseScaled(%q, %d) failed: %v", test.text, test.scale, err)
+			continue
+		}
+		if got := formatScaled(value, test.scale); got != test.expected {
+			t.Errorf("parseScaled(%q, %d) formats as %q, expected %q", test.text, test.scale, got, test.expected)
+		}
+	}
+	for _, text := range []string{"0.001", "1/3", "abc", "NaN"} {
+		if _, err := parseScaled(text, 2); err == nil {
+			t.Errorf("Expected parseScaled(%q, 2) to fail", text)
+		}
+	}
+}
+
+// TestClampScaled tests that hooks see the integer part of typed values within the int64 range
+func TestClampScaled(t *testing.T) {
+	huge, _ := new(big.Int).SetString("100000000000000000000", 10)
+	if got := clampScaled(huge, 0); got != math.MaxInt64 {
+		t.Errorf("Expected %d, got %d", int64(math.MaxInt64), got)
+	}
+	if got := clampScaled(big.NewInt(-1299), 2); got != -12 {
+		t.Errorf("Expected -12, got %d", got)
+	}
+}
+
+// TestTypedCounter tests adding to decimal and big counters and converting between types
+func TestTypedCounter(t *testing.T) {
+	useCounterDir(t)
+	path := counterPath("cash")
+	if err := storeCounter(path, 10); err != nil {
+		t.Fatalf("failed to store counter: %v", err)
+	}
+	if _, err := convertType(path, counterMeta{Type: TypeDecimal, Scale: 2}); err != nil {
+		t.Fatalf("convertType failed: %v", err)
+	}
+	if value, err := addTyped("cash", "0.25"); err != nil || value != "10.25" {
+		t.Errorf("Expected 10.25, got %q (%v)", value, err)
+	}
+	if _, _, err := updateCounter("cash", func(current int64) (int64, error) { return current + 1, nil }); err == nil {
+		t.Errorf("Expected an int64 update of a decimal counter to fail")
+	}
+	if _, err := convertType(path, counterMeta{Type: TypeInt}); err == nil {
+		t.Errorf("Expected converting 10.25 to int to fail")
+	}
+	if _, err := convertType(path, counterMeta{Type: TypeBig}); err == nil {
+		t.Errorf("Expected converting 10.25 to big to fail")
+	}
+
+	if _, err := convertType(counterPath("bytes"), counterMeta{Type: TypeBig}); err != nil {
+		t.Fatalf("convertType failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := addTyped("bytes", "9223372036854775807"); err != nil {
+			t.Fatalf("addTyped failed: %v", err)
+		}
+	}
+	if value, err := readTyped(counterPath("bytes")); err != nil || value != "18446744073709551614" {
+		t.Errorf("Expected 18446744073709551614, got %q (%v)", value, err)
+	}
+}
+
+// TestFloatCounter tests float gauges, their output precision and the rejection of NaN and infinite values
+func TestFloatCounter(t *testing.T) {
+	useCounterDir(t)
+	path := counterPath("temperature")
+	precision := 2
+	if _, err := convertType(path, counterMeta{Type: TypeFloat, Precision: &precision}); err != nil {
+		t.Fatalf("convertType failed: %v", err)
+	}
+	if value, err := addTyped("temperature", "21.5"); err != nil || value != "21.50" {
+		t.Errorf("Expected 21.50, got %q (%v)", value, err)
+	}
+	if value, err := addTyped("temperature", "-0.125"); err != nil || value != "21.38" {
+		t.Errorf("Expected 21.38, got %q (%v)", value, err)
+	}
+	if value, err := peekValue(path); err != nil || value != "21.38" {
+		t.Errorf("Expected peekValue to print 21.38, got %q (%v)", value, err)
+	}
+	for _, text := range []string{"NaN", "Inf", "-infinity", "1e400"} {
+		if _, err := addTyped("temperature", text); err == nil {
+			t.Errorf("Expected adding %s to fail", text)
+		}
+	}
+	if _, err := addTyped("temperature", "1.7e308"); err != nil {
+		t.Fatalf("addTyped failed: %v", err)
+	}
+	if _, err := addTyped("temperature", "1.7e308"); err == nil {
+		t.Errorf("Expected an overflow to infinity to fail")
+	}
+	meta := counterMeta{Type: TypeFloat}
+	if got := meta.wholeValue("-3.9"); got != -3 {
+		t.Errorf("Expected hooks to see -3, got %d", got)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// addClamped returns a + b, clamped to the int64 range instead of overflowing
+func addClamped(a, b int64) int64 {
+	if b > 0 && a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	if b < 0 && a < math.MinInt64-b {
+		return math.MinInt64
+	}
+	return a + b
+}
+
+// runAdd adds a quantity to a counter and, with -window, to the counter's current time bucket
+func runAdd(args []string) error {
+	var (
+		amount     = quantity
+		amountText string
+		window     time.Duration
+		retention  time.Duration
+	)
+	fs := newCommandFlags("add")
+	fs.Var(numberFlag{&amount, &amountText}, "q", "quantity to add to the counter")
+	fs.DurationVar(&window, "window", 0, "also count into time buckets of this size, such as 1m, 1h or 24h")
+	fs.DurationVar(&retention, "retention", 0, "how long buckets are kept (default 168 buckets)")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: counter add <name> [-q N] [-window D] [-retention D]")
+	}
+	if neverAdd {
+		return errors.New("add operation is disabled by the environment variable")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	name := positional[0]
+	path := counterPath(name)
+	meta, metaErr := readMeta(path)
+	if metaErr != nil {
+		return metaErr
+	}
+	if meta.typed() {
+		if window > 0 {
+			return fmt.Errorf("counter %s is a %s counter and cannot be windowed", name, meta.typeName())
+		}
+		if amountText == "" {
+			amountText = strconv.FormatInt(amount, 10)
+		}
+		value, addErr := addTyped(name, amountText)
+		if addErr != nil {
+			return fmt.Errorf("counter %s: %w", name, addErr)
+		}
+		fmt.Println(value)
+		return nil
+	}
+	if _, err := strconv.ParseInt(amountText, 10, 64); amountText != "" && err != nil {
+		return fmt.Errorf("%s is not an int64; change the type of the counter with counter type", amountText)
+	}
+	_, value, updateErr := updateCounter(name, func(current int64) (int64, error) {
+		if window > 0 {
+			if err := addToWindow(path, window, retention, amount); err != nil {
+				return current, err
+			}
+		}
+		return addClamped(current, amount), nil
+	})
+	if updateErr != nil {
+		return fmt.Errorf("counter %s: %w", name, updateErr)
+	}
+	fmt.Println(value)
+	return nil
+}
+
+// runGet prints the value of a counter, the summary or percentiles of a histogram counter or, with -window,
+// the total of its recent time buckets
+func runGet(args []string) error {
+	var (
+		window      time.Duration
+		percentiles = DefaultPercentiles
+	)
+	fs := newCommandFlags("get")
+	fs.DurationVar(&window, "window", 0, "sum the buckets that fall within this span, such as 24h")
+	fs.StringVar(&percentiles, "p", percentiles, "percentiles of a histogram counter, such as 50,95,99")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: counter get <name> [-window D] [-p 50,95,99]")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	name := positional[0]
+	path := counterPath(name)
+	meta, metaErr := readMeta(path)
+	if metaErr != nil {
+		return metaErr
+	}
+	if meta.Type == TypeHistogram && window <= 0 {
+		return printHistogram(os.Stdout, path, percentiles)
+	}
+	if percentiles != DefaultPercentiles {
+		return fmt.Errorf("counter %s is not a histogram; -p needs a histogram counter", name)
+	}
+	if window <= 0 {
+		value, readErr := readTyped(path)
+		if readErr != nil {
+			return readErr
+		}
+		fmt.Println(value)
+		return nil
+	}
+	state, ok, readErr := readWindow(path)
+	if readErr != nil {
+		return readErr
+	}
+	if !ok {
+		return fmt.Errorf("counter %s is not windowed; add to it with -window first", name)
+	}
+	fmt.Println(sumWindow(state, window))
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenBucket is the persisted state of a rate limiter; Rate is in tokens per second
+type tokenBucket struct {
+	Rate    float64   `json:"rate"`
+	Burst   float64   `json:"burst"`
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+}
+
+// parseRate parses rates such as "10/s", "100/m", "5/h", "1/d" or "3/500ms" into tokens per second
+func parseRate(text string) (float64, error) {
+	countText, per, ok := strings.Cut(text, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid rate %q: expected N/unit such as 10/s", text)
+	}
+	count, countErr := strconv.ParseFloat(countText, 64)
+	if countErr != nil || count <= 0 || math.IsInf(count, 0) {
+		return 0, fmt.Errorf("invalid rate %q: count must be a positive number", text)
+	}
+	units := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}
+	period, known := units[per]
+	if !known {
+		parsed, err := time.ParseDuration(per)
+		if err != nil || parsed <= 0 {
+			return 0, fmt.Errorf("invalid rate %q: unknown unit %q", text, per)
+		}
+		period = parsed
+	}
+	return count / period.Seconds(), nil
+}
+
+// formatRate formats tokens per second the way parseRate accepts it
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', -1, 64) + "/s"
+}
+
+// bucketPath returns the file that stores the token bucket of a rate limiter
+func bucketPath(filePath string) string {
+	return sidecarPath(filePath, "bucket")
+}
+
+// readBucket reads a token bucket; ok is false when the rate limiter has never been used
+func readBucket(filePath string) (bucket tokenBucket, ok bool, err error) {
+	data, readErr := os.ReadFile(bucketPath(filePath))
+	if readErr != nil {
+		if errors.Is(readErr, fs.ErrNotExist) {
+			return bucket, false, nil
+		}
+		return bucket, false, fmt.Errorf("failed to read rate limiter: %w", readErr)
+	}
+	if err := json.Unmarshal(data, &bucket); err != nil {
+		return bucket, false, fmt.Errorf("invalid rate limiter: %w", err)
+	}
+	return bucket, true, nil
+}
+
+// refill adds the tokens earned since the bucket was last updated
+func (b *tokenBucket) refill(at time.Time) {
+	if elapsed := at.Sub(b.Updated).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(b.Burst, b.Tokens+elapsed*b.Rate)
+	}
+	b.Updated = at
+}
+
+// wait returns how long until n tokens are available
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b.Tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.Tokens) / b.Rate * float64(time.Second))
+}
+
+// takeTokens removes n tokens from the limiter stored beside filePath, configuring it with rate and burst
+// when they are positive; when not enough tokens are available it returns how long to wait instead
+func takeTokens(filePath string, rate, burst, n float64) (tokenBucket, time.Duration, error) {
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return tokenBucket{}, 0, lockErr
+	}
+	defer unlock()
+	bucket, ok, readErr := readBucket(filePath)
+	if readErr != nil {
+		return bucket, 0, readErr
+	}
+	at := now()
+	if ok {
+		bucket.refill(at)
+	}
+	if rate > 0 {
+		bucket.Rate = rate
+	}
+	if burst > 0 {
+		bucket.Burst = burst
+		bucket.Tokens = math.Min(bucket.Tokens, burst)
+	}
+	if bucket.Rate <= 0 {
+		return bucket, 0, errors.New("rate limiter has no rate; pass -rate")
+	}
+	if bucket.Burst <= 0 {
+		bucket.Burst = math.Max(1, math.Ceil(bucket.Rate))
+	}
+	if !ok {
+		bucket.Tokens = bucket.Burst
+		bucket.Updated = at
+	}
+	if n > bucket.Burst {
+		return bucket, 0, fmt.Errorf("cannot acquire %g tokens from a bucket that holds %g", n, bucket.Burst)
+	}
+
+	wait := bucket.wait(n)
+	if wait == 0 {
+		bucket.Tokens -= n
+	}
+	data, marshalErr := json.Marshal(bucket)
+	if marshalErr != nil {
+		return bucket, 0, marshalErr
+	}
+	return bucket, wait, writeFileAtomic(bucketPath(filePath), data, 0600)
+}
+
+// runRateLimit acquires tokens from a persisted token bucket or shows its state
+func runRateLimit(args []string) error {
+	usage := errors.New("usage: counter ratelimit acquire <name> -rate N/unit [-burst N] [-n N] [-nowait] [-timeout D] | status <name>")
+	if len(args) == 0 {
+		return usage
+	}
+	var (
+		rateText string
+		burst    float64
+		tokens   = 1.0
+		noWait   bool
+		timeout  time.Duration
+	)
+	fs := newCommandFlags("ratelimit " + args[0])
+	if args[0] == "acquire" {
+		fs.StringVar(&rateText, "rate", "", "refill rate such as 10/s, 100/m or 5/h (required on first use)")
+		fs.Float64Var(&burst, "burst", 0, "bucket capacity (default one second of tokens)")
+		fs.Float64Var(&tokens, "n", tokens, "number of tokens to acquire")
+		fs.BoolVar(&noWait, "nowait", false, "fail immediately instead of waiting for tokens")
+		fs.DurationVar(&timeout, "timeout", 0, "give up after waiting this long (0 waits forever)")
+	}
+	positional, parseErr := parseCommandArgs(fs, args[1:])
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 1 {
+		return usage
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	name := positional[0]
+	path := counterPath(name)
+
+	switch args[0] {
+	case "acquire":
+		var rate float64
+		if rateText != "" {
+			parsed, err := parseRate(rateText)
+			if err != nil {
+				return err
+			}
+			rate = parsed
+		}
+		if tokens <= 0 {
+			return errors.New("-n must be positive")
+		}
+		if err := recordName(path, name); err != nil {
+			return err
+		}
+		started := time.Now()
+		for {
+			bucket, wait, takeErr := takeTokens(path, rate, burst, tokens)
+			if takeErr != nil {
+				return fmt.Errorf("rate limiter %s: %w", name, takeErr)
+			}
+			if wait == 0 {
+				fmt.Println(strconv.FormatFloat(bucket.Tokens, 'f', 2, 64))
+				return nil
+			}
+			if noWait {
+				return fmt.Errorf("rate limiter %s: rate limited, next token in %s", name, wait.Round(time.Millisecond))
+			}
+			if timeout > 0 && time.Since(started)+wait > timeout {
+				return fmt.Errorf("rate limiter %s: timed out after %s", name, timeout)
+			}
+			time.Sleep(wait)
+		}
+	case "status":
+		unlock, lockErr := lockFile(lockPath(path))
+		if lockErr != nil {
+			return lockErr
+		}
+		bucket, ok, readErr := readBucket(path)
+		unlock()
+		if readErr != nil {
+			return readErr
+		}
+		if !ok {
+			return fmt.Errorf("rate limiter %s has not been used", name)
+		}
+		bucket.refill(now())
+		full := time.Duration((bucket.Burst - bucket.Tokens) / bucket.Rate * float64(time.Second))
+		fmt.Printf("tokens: %s\n", strconv.FormatFloat(bucket.Tokens, 'f', 2, 64))
+		fmt.Printf("burst: %s\n", strconv.FormatFloat(bucket.Burst, 'f', -1, 64))
+		fmt.Printf("rate: %s\n", formatRate(bucket.Rate))
+		fmt.Printf("next token in: %s\n", bucket.wait(1).Round(time.Millisecond))
+		fmt.Printf("full in: %s\n", full.Round(time.Millisecond))
+		return nil
+	}
+	return usage
+}
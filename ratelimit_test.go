@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestParseRate tests the parseRate function
+func TestParseRate(t *testing.T) {
+	tests := map[string]float64{"10/s": 10, "120/m": 2, "36/h": 0.01, "1/500ms": 2}
+	for text, want := range tests {
+		got, err := parseRate(text)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", text, err)
+		}
+		if got != want {
+			t.Errorf("%s: expected %g, got %g", text, want, got)
+		}
+	}
+	for _, text := range []string{"10", "0/s", "-1/s", "x/s", "10/fortnight"} {
+		if _, err := parseRate(text); err == nil {
+			t.Errorf("Expected error for %q", text)
+		}
+	}
+}
+
+// TestTakeTokens tests that the token bucket refills at its rate and never exceeds its burst
+func TestTakeTokens(t *testing.T) {
+	useCounterDir(t)
+	advance := useClock(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	path := counterPath("api")
+
+	for i := 0; i < 2; i++ {
+		if _, wait, err := takeTokens(path, 1, 2, 1); err != nil || wait != 0 {
+			t.Fatalf("Expected token %d to be available, got wait %s (%v)", i+1, wait, err)
+		}
+	}
+	if _, wait, err := takeTokens(path, 0, 0, 1); err != nil || wait != time.Second {
+		t.Fatalf("Expected to wait 1s for the third token, got %s (%v)", wait, err)
+	}
+	advance(500 * time.Millisecond)
+	if _, wait, _ := takeTokens(path, 0, 0, 1); wait != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms, got %s", wait)
+	}
+	advance(500 * time.Millisecond)
+	if _, wait, _ := takeTokens(path, 0, 0, 1); wait != 0 {
+		t.Errorf("Expected a token after 1s, got wait %s", wait)
+	}
+	advance(time.Hour)
+	if bucket, _, _ := takeTokens(path, 0, 0, 1); bucket.Tokens != 1 {
+		t.Errorf("Expected the bucket to refill to its burst only, got %g tokens left", bucket.Tokens)
+	}
+	if _, _, err := takeTokens(path, 0, 0, 3); err == nil {
+		t.Errorf("Expected an error when acquiring more than the burst")
+	}
+}
+
+// TestTakeTokensConcurrently tests that concurrent acquirers never take more tokens than the bucket holds
+func TestTakeTokensConcurrently(t *testing.T) {
+	useCounterDir(t)
+	useClock(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	path := counterPath("api")
+	var acquired atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, wait, err := takeTokens(path, 1, 5, 1); err == nil && wait == 0 {
+				acquired.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if acquired.Load() != 5 {
+		t.Errorf("Expected 5 tokens to be acquired, got %d", acquired.Load())
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// MaxArchivedPeriods is the most periods a single read archives when the counter was not touched for many
+// periods; the first one holds the value and the ones dropped after it are empty
+const MaxArchivedPeriods int = 1000
+
+// resetSchedule resets a counter whenever a period boundary passes; Every is one of hour, day, week,
+// month or year, or Cron holds a five field cron expression, evaluated in the TZ location. Start is
+// when the current period began, which is when the schedule was set or the last boundary that passed.
+type resetSchedule struct {
+	Every string     `json:"every,omitempty"`
+	Cron  string     `json:"cron,omitempty"`
+	TZ    string     `json:"tz,omitempty"`
+	Start *time.Time `json:"start,omitempty"`
+}
+
+// archivedPeriod is the final value of a counter in a period that ended with a scheduled reset
+type archivedPeriod struct {
+	Ended time.Time `json:"ended"`
+	Value int64     `json:"value"`
+}
+
+// validate checks that the schedule can be evaluated
+func (s resetSchedule) validate() error {
+	if _, err := time.LoadLocation(s.TZ); err != nil {
+		return fmt.Errorf("invalid time zone %q: %w", s.TZ, err)
+	}
+	if s.Cron != "" {
+		if s.Every != "" {
+			return errors.New("use either -reset-every or -cron, not both")
+		}
+		// an expression such as 0 0 30 2 * parses but never fires, which would fail every read
+		_, err := s.next(now())
+		return err
+	}
+	switch s.Every {
+	case "hour", "day", "week", "month", "year":
+		return nil
+	}
+	return fmt.Errorf("invalid period %q: expected hour, day, week, month or year", s.Every)
+}
+
+// String describes the schedule
+func (s resetSchedule) String() string {
+	tz := s.TZ
+	if tz == "" {
+		tz = "UTC"
+	}
+	if s.Cron != "" {
+		return fmt.Sprintf("cron %q in %s", s.Cron, tz)
+	}
+	return fmt.Sprintf("every %s in %s", s.Every, tz)
+}
+
+// next returns the first period boundary strictly after t
+func (s resetSchedule) next(t time.Time) (time.Time, error) {
+	loc, locErr := time.LoadLocation(s.TZ)
+	if locErr != nil {
+		return time.Time{}, locErr
+	}
+	t = t.In(loc)
+	if s.Cron != "" {
+		c, err := parseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return c.next(t)
+	}
+	switch s.Every {
+	case "hour":
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc), nil
+	case "day":
+		return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc), nil
+	case "week":
+		// weeks start on Monday
+		days := (8 - int(t.Weekday())) % 7
+		if days == 0 {
+			days = 7
+		}
+		return time.Date(t.Year(), t.Month(), t.Day()+days, 0, 0, 0, 0, loc), nil
+	case "month":
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc), nil
+	case "year":
+		return time.Date(t.Year()+1, 1, 1, 0, 0, 0, 0, loc), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid period %q", s.Every)
+}
+
+// archivePath returns the file that stores the final values of past periods of a counter
+func archivePath(filePath string) string {
+	return sidecarPath(filePath, "archive")
+}
+
+// applySchedule resets the counter when period boundaries have passed since the current period began,
+// archiving the value it held at the first boundary and 0 for every later one; it returns the value to
+// use from now on and must be called with the counter lock held
+func applySchedule(filePath string, current int64) (int64, error) {
+	meta, metaErr := readMeta(filePath)
+	if metaErr != nil || meta.Schedule == nil {
+		return current, metaErr
+	}
+	_, statErr := os.Stat(filePath)
+	if statErr != nil && !errors.Is(statErr, fs.ErrNotExist) {
+		return current, statErr
+	}
+	var start time.Time
+	if meta.Schedule.Start != nil {
+		start = *meta.Schedule.Start
+	} else {
+		// schedules set before the period start was recorded begin with the last write
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return current, nil
+		}
+		start = info.ModTime()
+	}
+
+	var periods []archivedPeriod
+	value := current
+	for {
+		boundary, nextErr := meta.Schedule.next(start)
+		if nextErr != nil {
+			return current, nextErr
+		}
+		if boundary.After(now()) {
+			break
+		}
+		periods = append(periods, archivedPeriod{Ended: boundary, Value: value})
+		start, value = boundary, 0
+	}
+	if len(periods) == 0 {
+		return current, nil
+	}
+	if len(periods) > MaxArchivedPeriods {
+		periods = append(periods[:1], periods[len(periods)-MaxArchivedPeriods+1:]...)
+	}
+
+	var lines []byte
+	for _, period := range periods {
+		line, marshalErr := json.Marshal(period)
+		if marshalErr != nil {
+			return current, marshalErr
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	archive, openErr := os.OpenFile(archivePath(filePath), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if openErr != nil {
+		return current, openErr
+	}
+	_, writeErr := archive.Write(lines)
+	_ = archive.Close()
+	if writeErr != nil {
+		return current, writeErr
+	}
+	if statErr == nil {
+		if err := storeCounter(filePath, 0); err != nil {
+			return current, err
+		}
+	}
+	meta.Schedule.Start = &start
+	if err := writeMeta(filePath, meta); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// readArchive returns the archived periods of a counter, oldest first
+func readArchive(filePath string) ([]archivedPeriod, error) {
+	data, err := os.ReadFile(archivePath(filePath))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var periods []archivedPeriod
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var period archivedPeriod
+		if line == "" || json.Unmarshal([]byte(line), &period) != nil {
+			continue
+		}
+		periods = append(periods, period)
+	}
+	return periods, nil
+}
+
+// runSchedule manages the reset schedule of a counter
+func runSchedule(args []string) error {
+	usage := errors.New("usage: counter schedule set <name> (-reset-every period | -cron expr) [-tz zone] | show <name> | clear <name> | archive <name>")
+	if len(args) == 0 {
+		return usage
+	}
+	var schedule resetSchedule
+	fs := newCommandFlags("schedule " + args[0])
+	if args[0] == "set" {
+		fs.StringVar(&schedule.Every, "reset-every", "", "reset at the start of every hour, day, week, month or year")
+		fs.StringVar(&schedule.Cron, "cron", "", "reset whenever this five field cron expression fires")
+		fs.StringVar(&schedule.TZ, "tz", "", "time zone the schedule is evaluated in, such as Europe/Bucharest (default UTC)")
+	}
+	positional, parseErr := parseCommandArgs(fs, args[1:])
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 1 {
+		return usage
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	name := positional[0]
+	path := counterPath(name)
+
+	switch args[0] {
+	case "set":
+		if err := schedule.validate(); err != nil {
+			return err
+		}
+		editErr := editMeta(path, func(meta *counterMeta) error {
+			if meta.typed() {
+				return fmt.Errorf("counter %s is a %s counter and cannot be reset on a schedule", name, meta.typeName())
+			}
+			start := now()
+			schedule.Start = &start
+			meta.Schedule = &schedule
+			return nil
+		})
+		if editErr != nil {
+			return editErr
+		}
+		if err := recordName(path, name); err != nil {
+			return err
+		}
+		fmt.Printf("counter %s resets %s\n", name, schedule)
+		return nil
+	case "show":
+		meta, readErr := readMeta(path)
+		if readErr != nil {
+			return readErr
+		}
+		if meta.Schedule == nil {
+			fmt.Printf("counter %s has no reset schedule\n", name)
+			return nil
+		}
+		fmt.Printf("counter %s resets %s\n", name, meta.Schedule)
+		return nil
+	case "clear":
+		editErr := editMeta(path, func(meta *counterMeta) error {
+			meta.Schedule = nil
+			return nil
+		})
+		if editErr != nil {
+			return editErr
+		}
+		fmt.Printf("counter %s no longer resets on a schedule\n", name)
+		return nil
+	case "archive":
+		periods, readErr := readArchive(path)
+		if readErr != nil {
+			return readErr
+		}
+		for _, period := range periods {
+			fmt.Printf("%s\t%d\n", period.Ended.Format(time.RFC3339), period.Value)
+		}
+		return nil
+	}
+	return usage
+}
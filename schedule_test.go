@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestResetScheduleNext tests the resetSchedule.next function
+func TestResetScheduleNext(t *testing.T) {
+	bucharest, err := time.LoadLocation("Europe/Bucharest")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	from := time.Date(2026, 3, 11, 21, 30, 0, 0, time.UTC) // Wednesday 23:30 in Bucharest
+	tests := []struct {
+		schedule resetSchedule
+		want     time.Time
+	}{
+		{resetSchedule{Every: "hour"}, time.Date(2026, 3, 11, 22, 0, 0, 0, time.UTC)},
+		{resetSchedule{Every: "day"}, time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC)},
+		{resetSchedule{Every: "day", TZ: "Europe/Bucharest"}, time.Date(2026, 3, 12, 0, 0, 0, 0, bucharest)},
+		{resetSchedule{Every: "week"}, time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{resetSchedule{Every: "month"}, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{resetSchedule{Every: "year"}, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{resetSchedule{Cron: "0 6 * * *", TZ: "Europe/Bucharest"}, time.Date(2026, 3, 12, 6, 0, 0, 0, bucharest)},
+	}
+	for _, tt := range tests {
+		if err := tt.schedule.validate(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.schedule, err)
+		}
+		got, err := tt.schedule.next(from)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.schedule, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: expected %s, got %s", tt.schedule, tt.want, got)
+		}
+	}
+	if err := (resetSchedule{Every: "fortnight"}).validate(); err == nil {
+		t.Errorf("Expected error for an unknown period")
+	}
+	if err := (resetSchedule{Cron: "0 0 30 2 *"}).validate(); err == nil {
+		t.Errorf("Expected error for a cron expression that never fires")
+	}
+}
+
+// TestApplySchedule tests that a counter is reset and archived once a period boundary has passed
+func TestApplySchedule(t *testing.T) {
+	useCounterDir(t)
+	lastWrite := time.Date(2026, 5, 31, 18, 0, 0, 0, time.UTC)
+	advance := useClock(t, lastWrite)
+	path := counterPath("quota")
+	if err := storeCounter(path, 42); err != nil {
+		t.Fatalf("failed to write counter: %v", err)
+	}
+	if err := os.Chtimes(path, lastWrite, lastWrite); err != nil {
+		t.Fatalf("failed to set modification time: %v", err)
+	}
+	if err := editMeta(path, func(meta *counterMeta) error {
+		meta.Schedule = &resetSchedule{Every: "month"}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to set schedule: %v", err)
+	}
+
+	advance(5 * time.Hour)
+	if value, err := currentValue(path); err != nil || value != 42 {
+		t.Fatalf("Expected 42 before the boundary, got %d (%v)", value, err)
+	}
+	advance(time.Hour)
+	if value, err := currentValue(path); err != nil || value != 0 {
+		t.Fatalf("Expected 0 after the boundary, got %d (%v)", value, err)
+	}
+	periods, err := readArchive(path)
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	if len(periods) != 1 || periods[0].Value != 42 || !periods[0].Ended.Equal(time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the May total to be archived, got %+v", periods)
+	}
+}
+
+// TestApplyScheduleArchivesEveryPeriod tests that the period start is kept in the metadata and that every
+// boundary passed since is archived
+func TestApplyScheduleArchivesEveryPeriod(t *testing.T) {
+	useCounterDir(t)
+	advance := useClock(t, time.Date(2026, 5, 31, 18, 0, 0, 0, time.UTC))
+	path := counterPath("quota")
+	start := now()
+	if err := editMeta(path, func(meta *counterMeta) error {
+		meta.Schedule = &resetSchedule{Every: "month", Start: &start}
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to set schedule: %v", err)
+	}
+	if err := storeCounter(path, 42); err != nil {
+		t.Fatalf("failed to write counter: %v", err)
+	}
+
+	advance(62 * 24 * time.Hour)
+	if value, err := currentValue(path); err != nil || value != 0 {
+		t.Fatalf("Expected 0 after the boundaries, got %d (%v)", value, err)
+	}
+	periods, err := readArchive(path)
+	if err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	if len(periods) != 3 || periods[0].Value != 42 || periods[1].Value != 0 || periods[2].Value != 0 ||
+		!periods[2].Ended.Equal(time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected May, June and July to be archived, got %+v", periods)
+	}
+	meta, _ := readMeta(path)
+	if meta.Schedule.Start == nil || !meta.Schedule.Start.Equal(periods[2].Ended) {
+		t.Errorf("Expected the period to start at the last boundary, got %v", meta.Schedule.Start)
+	}
+	if value, err := currentValue(path); err != nil || value != 0 {
+		t.Fatalf("Expected 0 within the period, got %d (%v)", value, err)
+	}
+	if periods, _ := readArchive(path); len(periods) != 3 {
+		t.Errorf("Expected no further periods, got %+v", periods)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultSemLease    time.Duration = 30 * time.Minute
+	DefaultSemInterval time.Duration = 250 * time.Millisecond
+)
+
+// semLease is a slot of a semaphore held by a process until it releases it or the lease expires
+type semLease struct {
+	ID       string    `json:"id"`
+	PID      int       `json:"pid"`
+	Host     string    `json:"host"`
+	Acquired time.Time `json:"acquired"`
+	Expires  time.Time `json:"expires"`
+	Command  string    `json:"command,omitempty"`
+}
+
+// semaphore is the persisted state of a counting semaphore
+type semaphore struct {
+	Max    int        `json:"max"`
+	Leases []semLease `json:"leases"`
+}
+
+// semPath returns the file that stores the leases of a semaphore
+func semPath(filePath string) string {
+	return sidecarPath(filePath, "sem")
+}
+
+// readSemaphore reads the state of a semaphore, returning an empty semaphore when it has never been used
+func readSemaphore(filePath string) (semaphore, error) {
+	var sem semaphore
+	data, err := os.ReadFile(semPath(filePath))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return sem, nil
+		}
+		return sem, fmt.Errorf("failed to read semaphore: %w", err)
+	}
+	if err := json.Unmarshal(data, &sem); err != nil {
+		return sem, fmt.Errorf("invalid semaphore: %w", err)
+	}
+	return sem, nil
+}
+
+// processAlive reports whether a process with pid exists on this host
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// reclaim drops leases that have expired or whose process on this host has exited
+func (s *semaphore) reclaim(at time.Time, host string) {
+	kept := s.Leases[:0]
+	for _, lease := range s.Leases {
+		if !lease.Expires.After(at) {
+			continue
+		}
+		if lease.Host == host && !processAlive(lease.PID) {
+			continue
+		}
+		kept = append(kept, lease)
+	}
+	s.Leases = kept
+}
+
+// editSemaphore applies fn to a semaphore, after reclaiming stale leases, while holding the counter lock
+func editSemaphore(filePath string, fn func(*semaphore) error) error {
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return lockErr
+	}
+	defer unlock()
+	sem, readErr := readSemaphore(filePath)
+	if readErr != nil {
+		return readErr
+	}
+	host, _ := os.Hostname()
+	sem.reclaim(now(), host)
+	if err := fn(&sem); err != nil {
+		return err
+	}
+	data, marshalErr := json.MarshalIndent(sem, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return writeFileAtomic(semPath(filePath), data, 0600)
+}
+
+// acquireSlot takes a slot for this process when fewer than limit are held; ok is false when all slots are taken
+func acquireSlot(filePath string, limit int, lease time.Duration, command string) (held semLease, ok bool, err error) {
+	err = editSemaphore(filePath, func(sem *semaphore) error {
+		if limit > 0 {
+			sem.Max = limit
+		}
+		if sem.Max <= 0 {
+			return errors.New("semaphore has no limit; pass -max")
+		}
+		if len(sem.Leases) >= sem.Max {
+			return nil
+		}
+		at := now()
+		host, _ := os.Hostname()
+		held = semLease{
+			ID:       fmt.Sprintf("%s-%d-%d", host, os.Getpid(), at.UnixNano()),
+			PID:      os.Getpid(),
+			Host:     host,
+			Acquired: at,
+			Expires:  at.Add(lease),
+			Command:  command,
+		}
+		sem.Leases = append(sem.Leases, held)
+		ok = true
+		return nil
+	})
+	return held, ok, err
+}
+
+// renewSlot extends a lease so that long running commands keep their slot
+func renewSlot(filePath, id string, lease time.Duration) error {
+	return editSemaphore(filePath, func(sem *semaphore) error {
+		for i := range sem.Leases {
+			if sem.Leases[i].ID == id {
+				sem.Leases[i].Expires = now().Add(lease)
+				return nil
+			}
+		}
+		return fmt.Errorf("lease %s was reclaimed", id)
+	})
+}
+
+// releaseSlot gives a slot back
+func releaseSlot(filePath, id string) error {
+	return editSemaphore(filePath, func(sem *semaphore) error {
+		for i, lease := range sem.Leases {
+			if lease.ID == id {
+				sem.Leases = append(sem.Leases[:i], sem.Leases[i+1:]...)
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// runSemaphore limits how many commands run at once across processes, or lists the current holders
+func runSemaphore(args []string) error {
+	usage := errors.New("usage: counter sem acquire <name> -max N [-lease D] [-nowait] [-timeout D] -- <command> | list [pattern]")
+	if len(args) == 0 {
+		return usage
+	}
+	var command []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, command = args[:i], args[i+1:]
+			break
+		}
+	}
+	if len(args) == 0 {
+		return usage
+	}
+	var (
+		limit   int
+		lease   = DefaultSemLease
+		noWait  bool
+		timeout time.Duration
+	)
+	fs := newCommandFlags("sem " + args[0])
+	if args[0] == "acquire" {
+		fs.IntVar(&limit, "max", 0, "number of slots (required on first use)")
+		fs.DurationVar(&lease, "lease", lease, "how long a slot is held without renewal before it is reclaimed")
+		fs.BoolVar(&noWait, "nowait", false, "fail immediately when every slot is taken")
+		fs.DurationVar(&timeout, "timeout", 0, "give up after waiting this long (0 waits forever)")
+	}
+	positional, parseErr := parseCommandArgs(fs, args[1:])
+	if parseErr != nil {
+		return parseErr
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "acquire":
+		if len(positional) != 1 || len(command) == 0 {
+			return usage
+		}
+		if lease <= 0 {
+			return errors.New("-lease must be positive")
+		}
+		name := positional[0]
+		path := counterPath(name)
+		if err := recordName(path, name); err != nil {
+			return err
+		}
+		started := time.Now()
+		for {
+			held, ok, acquireErr := acquireSlot(path, limit, lease, strings.Join(command, " "))
+			if acquireErr != nil {
+				return fmt.Errorf("semaphore %s: %w", name, acquireErr)
+			}
+			if ok {
+				return runHoldingSlot(path, held, lease, command)
+			}
+			if noWait {
+				return fmt.Errorf("semaphore %s: every slot is taken", name)
+			}
+			if timeout > 0 && time.Since(started) > timeout {
+				return fmt.Errorf("semaphore %s: timed out after %s", name, timeout)
+			}
+			time.Sleep(DefaultSemInterval)
+		}
+	case "list":
+		pattern := "*"
+		if len(positional) == 1 {
+			pattern = positional[0]
+		}
+		return listSemaphores(pattern)
+	}
+	return usage
+}
+
+// runHoldingSlot runs command while renewing its lease and releases the slot when the command exits
+func runHoldingSlot(path string, held semLease, lease time.Duration, command []string) error {
+	defer func() {
+		if err := releaseSlot(path, held.ID); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not release semaphore slot: %v\n", err)
+		}
+	}()
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// keep running after an interrupt so the slot is released, passing the signal on to the command
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	renew := time.NewTicker(lease / 3)
+	defer renew.Stop()
+	for {
+		select {
+		case sig := <-signals:
+			_ = cmd.Process.Signal(sig)
+		case <-renew.C:
+			if err := renewSlot(path, held.ID, lease); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not renew semaphore lease: %v\n", err)
+			}
+		case waitErr := <-exited:
+			var exitErr *exec.ExitError
+			if errors.As(waitErr, &exitErr) {
+				return exitCodeError{Code: childExitCode(exitErr)}
+			}
+			return waitErr
+		}
+	}
+}
+
+// childExitCode returns the exit code of a command the way a shell reports it, which is 128 plus the signal
+// number for a command killed by a signal, such as 137 for SIGKILL
+func childExitCode(exitErr *exec.ExitError) int {
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+// listSemaphores prints the holders of every semaphore whose name matches pattern
+func listSemaphores(pattern string) error {
+	names, namesErr := loadNames(counterDir)
+	if namesErr != nil {
+		return namesErr
+	}
+	entries, readErr := os.ReadDir(counterDir)
+	if readErr != nil {
+		return readErr
+	}
+	var found []string
+	for _, entry := range entries {
+		name, ok := sidecarOwner(entry.Name(), "sem", names)
+		if ok && matchName(pattern, name) {
+			found = append(found, name)
+		}
+	}
+	sort.Strings(found)
+	host, _ := os.Hostname()
+	for _, name := range found {
+		path := counterPath(name)
+		sem, err := readSemaphore(path)
+		if err != nil {
+			return err
+		}
+		sem.reclaim(now(), host)
+		fmt.Printf("%s %d/%d\n", name, len(sem.Leases), sem.Max)
+		for _, lease := range sem.Leases {
+			fmt.Printf("  pid %d on %s since %s, expires %s: %s\n", lease.PID, lease.Host,
+				lease.Acquired.Format(time.RFC3339), lease.Expires.Format(time.RFC3339), lease.Command)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// TestAcquireSlot tests that a semaphore hands out at most its limit of slots and takes them back on release
+func TestAcquireSlot(t *testing.T) {
+	useCounterDir(t)
+	path := counterPath("builds")
+	var leases []semLease
+	for i := 0; i < 2; i++ {
+		lease, ok, err := acquireSlot(path, 2, time.Minute, "make")
+		if err != nil || !ok {
+			t.Fatalf("Expected slot %d to be available (%v)", i+1, err)
+		}
+		leases = append(leases, lease)
+	}
+	if _, ok, err := acquireSlot(path, 0, time.Minute, "make"); err != nil || ok {
+		t.Fatalf("Expected every slot to be taken (%v)", err)
+	}
+	if err := releaseSlot(path, leases[0].ID); err != nil {
+		t.Fatalf("failed to release slot: %v", err)
+	}
+	if _, ok, err := acquireSlot(path, 0, time.Minute, "make"); err != nil || !ok {
+		t.Fatalf("Expected the released slot to be available (%v)", err)
+	}
+	if _, _, err := acquireSlot(counterPath("unconfigured"), 0, time.Minute, "make"); err == nil {
+		t.Errorf("Expected an error for a semaphore without a limit")
+	}
+}
+
+// TestReclaimSlots tests that expired leases and leases of exited processes are reclaimed
+func TestReclaimSlots(t *testing.T) {
+	useCounterDir(t)
+	advance := useClock(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	path := counterPath("builds")
+	if _, ok, err := acquireSlot(path, 1, time.Minute, "make"); err != nil || !ok {
+		t.Fatalf("Expected a slot (%v)", err)
+	}
+	advance(2 * time.Minute)
+	if _, ok, err := acquireSlot(path, 1, time.Minute, "make"); err != nil || !ok {
+		t.Fatalf("Expected the expired lease to be reclaimed (%v)", err)
+	}
+
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skipf("cannot start a process: %v", err)
+	}
+	host, _ := os.Hostname()
+	err := editSemaphore(path, func(sem *semaphore) error {
+		sem.Leases = []semLease{{ID: "crashed", PID: exited.Process.Pid, Host: host, Expires: now().Add(time.Hour)}}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to edit semaphore: %v", err)
+	}
+	if _, ok, err := acquireSlot(path, 1, time.Minute, "make"); err != nil || !ok {
+		t.Fatalf("Expected the lease of the exited process to be reclaimed (%v)", err)
+	}
+}
+
+// TestChildExitCode tests that commands killed by a signal exit with 128 plus the signal number
+func TestChildExitCode(t *testing.T) {
+	tests := map[string]int{"exit 3": 3, "kill -KILL $$": 137, "kill -TERM $$": 143}
+	for script, expected := range tests {
+		err := exec.Command("sh", "-c", script).Run()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatalf("Expected %q to fail, got %v", script, err)
+		}
+		if code := childExitCode(exitErr); code != expected {
+			t.Errorf("Expected %q to exit with %d, got %d", script, expected, code)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultSequenceFormat string = "{value}"
+
+// sequenceScope returns the counter that holds the sequence for the period containing at
+func sequenceScope(name, scope string, at time.Time) (string, error) {
+	switch scope {
+	case "", "none":
+		return name, nil
+	case "year":
+		return name + "." + at.Format("2006"), nil
+	case "month":
+		return name + "." + at.Format("2006-01"), nil
+	case "day":
+		return name + "." + at.Format("2006-01-02"), nil
+	}
+	return "", fmt.Errorf("invalid scope %q: expected none, year, month or day", scope)
+}
+
+// formatSequence expands the placeholders {value}, {value:0N}, {name}, {year}, {month} and {day} in format
+func formatSequence(format, name string, value int64, at time.Time) (string, error) {
+	var out strings.Builder
+	for {
+		start := strings.IndexByte(format, '{')
+		if start < 0 {
+			out.WriteString(format)
+			return out.String(), nil
+		}
+		end := strings.IndexByte(format[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated placeholder in %q", format)
+		}
+		out.WriteString(format[:start])
+		placeholder := format[start+1 : start+end]
+		format = format[start+end+1:]
+
+		key, spec, hasSpec := strings.Cut(placeholder, ":")
+		switch key {
+		case "value":
+			if !hasSpec {
+				out.WriteString(strconv.FormatInt(value, 10))
+				continue
+			}
+			width, err := strconv.Atoi(spec)
+			if err != nil || width < 0 || !strings.HasPrefix(spec, "0") {
+				return "", fmt.Errorf("invalid width %q in {value:%s}: expected a zero padded width such as 06", spec, spec)
+			}
+			out.WriteString(fmt.Sprintf("%0*d", width, value))
+		case "name":
+			out.WriteString(name)
+		case "year":
+			out.WriteString(at.Format("2006"))
+		case "month":
+			out.WriteString(at.Format("01"))
+		case "day":
+			out.WriteString(at.Format("02"))
+		default:
+			return "", fmt.Errorf("unknown placeholder {%s}", placeholder)
+		}
+		if hasSpec && key != "value" {
+			return "", fmt.Errorf("placeholder {%s} does not take a width", key)
+		}
+	}
+}
+
+// tombstonePath returns the file that remembers the highest value a deleted or lowered counter reached
+func tombstonePath(filePath string) string {
+	return sidecarPath(filePath, "tombstone")
+}
+
+// readTombstone returns the highest value a deleted or lowered counter reached, or 0 when it was neither
+func readTombstone(filePath string) (int64, error) {
+	return readCounter(tombstonePath(filePath))
+}
+
+// keepsTombstone reports whether deleting the counter must remember its value, which is the case once
+// a sequence was created with -tombstone or the counter has been deleted under a tombstone before
+func keepsTombstone(filePath string) bool {
+	if meta, err := readMeta(filePath); err == nil && meta.Tombstone {
+		return true
+	}
+	floor, err := readTombstone(filePath)
+	return err == nil && floor != 0
+}
+
+// writeTombstone remembers value for a counter that is about to be deleted so that it is never handed out again
+func writeTombstone(filePath string, value int64) error {
+	previous, readErr := readTombstone(filePath)
+	if readErr != nil {
+		return readErr
+	}
+	if previous >= value {
+		return nil
+	}
+	return writeFileAtomic(tombstonePath(filePath), []byte(strconv.FormatInt(value, 10)), 0400)
+}
+
+// nextInSequence atomically increments the sequence counter, never going below its tombstone
+func nextInSequence(name string, tombstone bool) (int64, error) {
+	path := counterPath(name)
+	if tombstone {
+		if err := editMeta(path, func(meta *counterMeta) error {
+			meta.Tombstone = true
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+	_, value, err := updateCounter(name, func(current int64) (int64, error) {
+		floor, readErr := readTombstone(path)
+		if readErr != nil {
+			return current, readErr
+		}
+		if floor > current {
+			current = floor
+		}
+		if current == 1<<63-1 {
+			return current, errors.New("sequence is exhausted")
+		}
+		return current + 1, nil
+	})
+	return value, err
+}
+
+// runNext increments a sequence and prints the new value as a formatted identifier
+func runNext(args []string) error {
+	var (
+		format    = DefaultSequenceFormat
+		scope     string
+		tombstone bool
+	)
+	fs := newCommandFlags("next")
+	fs.StringVar(&format, "format", format, "identifier template such as 'INV-{year}-{value:06}'")
+	fs.StringVar(&scope, "scope", "none", "start a new sequence every year, month or day")
+	fs.BoolVar(&tombstone, "tombstone", false, "never reuse numbers after the sequence is deleted")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: counter next <name> [-format template] [-scope none|year|month|day] [-tombstone]")
+	}
+	if neverAdd {
+		return errors.New("add operation is disabled by the environment variable")
+	}
+	at := now()
+	if _, err := formatSequence(format, positional[0], 0, at); err != nil {
+		return err
+	}
+	name, scopeErr := sequenceScope(positional[0], scope, at)
+	if scopeErr != nil {
+		return scopeErr
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	value, nextErr := nextInSequence(name, tombstone)
+	if nextErr != nil {
+		return fmt.Errorf("sequence %s: %w", name, nextErr)
+	}
+	id, formatErr := formatSequence(format, positional[0], value, at)
+	if formatErr != nil {
+		return formatErr
+	}
+	_, err := fmt.Fprintln(os.Stdout, id)
+	return err
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestFormatSequence tests the expansion of sequence placeholders
+func TestFormatSequence(t *testing.T) {
+	at := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	tests := map[string]string{
+		"{value}":                "42",
+		"INV-{year}-{value:06}":  "INV-2026-000042",
+		"{name}/{month}/{day}":   "invoices/10/19",
+		"no placeholders":        "no placeholders",
+		"{value:02}-{value:000}": "42-42",
+	}
+	for format, expected := range tests {
+		got, err := formatSequence(format, "invoices", 42, at)
+		if err != nil {
+			t.Errorf("formatSequence(%q) failed: %v", format, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("formatSequence(%q) = %q, expected %q", format, got, expected)
+		}
+	}
+	for _, format := range []string{"{unknown}", "{value:6}", "{value:0x}", "{year:04}", "INV-{value"} {
+		if _, err := formatSequence(format, "invoices", 42, at); err == nil {
+			t.Errorf("Expected formatSequence(%q) to fail", format)
+		}
+	}
+}
+
+// TestSequenceScope tests that scoped sequences use a counter per period
+func TestSequenceScope(t *testing.T) {
+	at := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	tests := map[string]string{
+		"none":  "invoices",
+		"year":  "invoices.2026",
+		"month": "invoices.2026-10",
+		"day":   "invoices.2026-10-19",
+	}
+	for scope, expected := range tests {
+		if got, err := sequenceScope("invoices", scope, at); err != nil || got != expected {
+			t.Errorf("sequenceScope(%q) = %q, %v, expected %q", scope, got, err, expected)
+		}
+	}
+	if _, err := sequenceScope("invoices", "week", at); err == nil {
+		t.Errorf("Expected an error for an unknown scope")
+	}
+}
+
+// TestNextInSequenceConcurrent tests that concurrent callers never receive the same value
+func TestNextInSequenceConcurrent(t *testing.T) {
+	useCounterDir(t)
+	const callers = 20
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		issued = make(map[int64]bool)
+	)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := nextInSequence("invoices", false)
+			if err != nil {
+				t.Errorf("nextInSequence failed: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if issued[value] {
+				t.Errorf("Value %d was issued twice", value)
+			}
+			issued[value] = true
+		}()
+	}
+	wg.Wait()
+	for value := int64(1); value <= callers; value++ {
+		if !issued[value] {
+			t.Errorf("Expected value %d to be issued", value)
+		}
+	}
+}
+
+// TestNextInSequenceTombstone tests that a deleted sequence continues after its highest value
+func TestNextInSequenceTombstone(t *testing.T) {
+	useCounterDir(t)
+	for i := 0; i < 3; i++ {
+		if _, err := nextInSequence("invoices", true); err != nil {
+			t.Fatalf("nextInSequence failed: %v", err)
+		}
+	}
+	path := counterPath("invoices")
+	if !keepsTombstone(path) {
+		t.Fatalf("Expected the sequence to keep a tombstone")
+	}
+	if err := writeTombstone(path, 3); err != nil {
+		t.Fatalf("failed to write tombstone: %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("failed to delete counter: %v", err)
+	}
+	removeSidecars(path)
+	if !keepsTombstone(path) {
+		t.Errorf("Expected the tombstone to outlive the counter metadata")
+	}
+	value, err := nextInSequence("invoices", false)
+	if err != nil {
+		t.Fatalf("nextInSequence failed: %v", err)
+	}
+	if value != 4 {
+		t.Errorf("Expected the sequence to continue at 4, got %d", value)
+	}
+}
+
+// TestNextInSequenceAfterReset tests that resetting or lowering a sequence kept under a tombstone never
+// reissues a number
+func TestNextInSequenceAfterReset(t *testing.T) {
+	useCounterDir(t)
+	for i := 0; i < 5; i++ {
+		if _, err := nextInSequence("invoices", true); err != nil {
+			t.Fatalf("nextInSequence failed: %v", err)
+		}
+	}
+	if _, _, err := updateCounter("invoices", func(int64) (int64, error) { return 0, nil }); err != nil {
+		t.Fatalf("failed to reset the sequence: %v", err)
+	}
+	if value, err := nextInSequence("invoices", false); err != nil || value != 6 {
+		t.Errorf("Expected the sequence to continue at 6 after a reset, got %d (%v)", value, err)
+	}
+	if _, _, err := updateCounter("invoices", func(int64) (int64, error) { return 2, nil }); err != nil {
+		t.Fatalf("failed to set the sequence: %v", err)
+	}
+	if value, err := nextInSequence("invoices", false); err != nil || value != 7 {
+		t.Errorf("Expected the sequence to continue at 7 after a set, got %d (%v)", value, err)
+	}
+	if floor, _ := readTombstone(counterPath("invoices")); floor != 6 {
+		t.Errorf("Expected the high-water mark 6, got %d", floor)
+	}
+}
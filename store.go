@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// sidecarPath returns the hidden file beside a counter file that stores data of the given kind
+func sidecarPath(filePath, kind string) string {
+	dir, file := filepath.Split(filePath)
+	return filepath.Join(dir, "."+strings.TrimPrefix(file, ".")+"."+kind)
+}
+
+// lockPath returns the hidden lock file that guards read-modify-write cycles on a counter file
+func lockPath(filePath string) string {
+	return sidecarPath(filePath, "lock")
+}
+
+// lockFile takes an exclusive advisory lock on path, creating it when needed, and returns the function that releases it
+func lockFile(path string) (func(), error) {
+	file, openErr := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if openErr != nil {
+		return nil, openErr
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		_ = file.Close()
+		return nil, os.NewSyscallError("flock", err)
+	}
+	return func() {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		_ = file.Close()
+	}, nil
+}
+
+// tryLockFile takes an exclusive advisory lock on path without waiting; ok is false when another process holds it
+func tryLockFile(path string) (unlock func(), ok bool, err error) {
+	file, openErr := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if openErr != nil {
+		return nil, false, openErr
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, false, nil
+		}
+		return nil, false, os.NewSyscallError("flock", err)
+	}
+	return func() {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		_ = file.Close()
+	}, true, nil
+}
+
+// writeFileAtomic writes data to a temporary file beside path and renames it into place
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, tmpErr := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if tmpErr != nil {
+		return tmpErr
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// currentValue reads a counter under its lock, applying a scheduled reset that is due
+func currentValue(filePath string) (int64, error) {
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return 0, lockErr
+	}
+	defer unlock()
+	if err := requireInteger(filePath); err != nil {
+		return 0, err
+	}
+	value, readErr := readCounter(filePath)
+	if readErr != nil {
+		return 0, readErr
+	}
+	return applySchedule(filePath, value)
+}
+
+// removeSidecars removes the hidden files kept beside a deleted counter file
+func removeSidecars(filePath string) {
+	for _, path := range []string{windowPath(filePath), metaPath(filePath), archivePath(filePath), bucketPath(filePath), semPath(filePath), historyPath(filePath)} {
+		_ = os.Remove(path)
+	}
+}
+
+// storeCounter records value as set by the local node in the PN-counter state of the counter file,
+// keeping the permissions of an existing file; a sequence kept under a tombstone that is lowered, such as
+// by -set or -reset, remembers the value it reached so that next never hands it out again. The caller
+// must hold the counter lock.
+func storeCounter(filePath string, value int64) error {
+	state, readErr := readPN(filePath)
+	if readErr != nil {
+		return readErr
+	}
+	if current := state.value(); value < current && keepsTombstone(filePath) {
+		if err := writeTombstone(filePath, current); err != nil {
+			return err
+		}
+	}
+	if err := state.setValue(localNode(), value); err != nil {
+		return err
+	}
+	return storeCounterText(filePath, state.String())
+}
+
+// storeCounterText writes the text of a value to the counter file, keeping the permissions of an existing file
+func storeCounterText(filePath, text string) error {
+	info, infoErr := os.Stat(filePath)
+	if infoErr == nil {
+		_ = os.Chmod(filePath, 0600)
+	}
+	file, fileErr := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0500)
+	if fileErr != nil {
+		return fileErr
+	}
+	defer file.Close()
+	if writeErr := writeCounterText(filePath, text, file); writeErr != nil {
+		return writeErr
+	}
+	if infoErr == nil {
+		_ = os.Chmod(filePath, info.Mode())
+	}
+	return nil
+}
+
+// updateCounter applies fn to the named counter while holding its lock, stores the result and
+// runs the actions attached to the counter; it returns the previous and the new value
+func updateCounter(name string, fn func(current int64) (int64, error)) (int64, int64, error) {
+	filePath := counterPath(name)
+	unlock, lockErr := lockFile(lockPath(filePath))
+	if lockErr != nil {
+		return 0, 0, lockErr
+	}
+	if err := requireInteger(filePath); err != nil {
+		unlock()
+		return 0, 0, err
+	}
+	current, readErr := readCounter(filePath)
+	if readErr == nil {
+		current, readErr = applySchedule(filePath, current)
+	}
+	if readErr != nil {
+		unlock()
+		return 0, 0, readErr
+	}
+	next, fnErr := fn(current)
+	if fnErr != nil {
+		unlock()
+		return current, current, fnErr
+	}
+	if err := storeCounter(filePath, next); err != nil {
+		unlock()
+		return current, current, err
+	}
+	if err := recordName(filePath, name); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not record counter name: %v\n", err)
+	}
+	unlock()
+	afterMutation(counterDir, name, filePath, current, next)
+	return current, next, nil
+}
+
+// afterMutation runs the actions configured in the counter directory dir for the named counter once a new
+// value has been stored in filePath
+func afterMutation(dir, name, filePath string, previous, value int64) {
+	if err := fireHooks(dir, name, previous, value); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not run hooks: %v\n", err)
+	}
+	queued, queueErr := queueWebhooks(dir, name, previous, value)
+	if queueErr != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not queue webhooks: %v\n", queueErr)
+	}
+	if queued > 0 {
+		if err := startWebhookFlush(dir); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not start delivering webhooks: %v\n", err)
+		}
+	}
+	if err := recordHistory(filePath, previous, value); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not record counter history: %v\n", err)
+	}
+	if err := commitChange(dir, name, filePath, previous, value); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Warning: Could not commit the change to git: %v\n", err)
+	}
+}
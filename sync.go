@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// syncChange is a change that a sync made, or would make, to a counter in a directory
+type syncChange struct {
+	Dir  string `json:"dir"`
+	Name string `json:"name"`
+	What string `json:"what"`
+}
+
+// String formats the change as a single line of text
+func (c syncChange) String() string {
+	return fmt.Sprintf("%s: %s (%s)", c.Name, c.What, c.Dir)
+}
+
+// wholeCounter returns the value of a counter of any type as hooks and webhooks see it
+func wholeCounter(filePath string) int64 {
+	meta, _ := readMeta(filePath)
+	if meta.typed() {
+		value, _ := readStored(filePath, meta)
+		return meta.wholeValue(value)
+	}
+	value, _ := readCounter(filePath)
+	return value
+}
+
+// sameMeta reports whether two counters have identical metadata
+func sameMeta(a, b counterMeta) bool {
+	left, _ := json.Marshal(a)
+	right, _ := json.Marshal(b)
+	return string(left) == string(right)
+}
+
+// replaceCounter copies the value and metadata of a counter from src to dst, for counters that cannot be
+// merged because one side is typed; it reports whether dst changed
+func replaceCounter(src, dst string, srcMeta counterMeta, name string, dryRun bool) (string, bool, error) {
+	data, readErr := os.ReadFile(src)
+	if readErr != nil && !os.IsNotExist(readErr) {
+		return "", false, readErr
+	}
+	unlock, lockErr := lockFile(lockPath(dst))
+	if lockErr != nil {
+		return "", false, lockErr
+	}
+	dstMeta, metaErr := readMeta(dst)
+	if metaErr != nil {
+		unlock()
+		return "", false, metaErr
+	}
+	current, _ := os.ReadFile(dst)
+	if string(current) == string(data) && sameMeta(srcMeta, dstMeta) {
+		unlock()
+		return "", false, nil
+	}
+	before, _ := peekValue(dst)
+	after, _ := peekValue(src)
+	what := fmt.Sprintf("%s -> %s", before, after)
+	if dryRun {
+		unlock()
+		return what, true, nil
+	}
+	old := wholeCounter(dst)
+	if err := writeMeta(dst, srcMeta); err != nil {
+		unlock()
+		return "", false, err
+	}
+	if err := storeCounterText(dst, string(data)); err != nil {
+		unlock()
+		return "", false, err
+	}
+	unlock()
+	if value := wholeCounter(dst); value != old {
+		afterMutation(filepath.Dir(dst), name, dst, old, value)
+	}
+	return what, true, nil
+}
+
+// syncCounter brings a counter of the from directory into the to directory: int counters are merged
+// as PN-counters and unique counters as sketches, while other typed counters and the metadata of a
+// counter are copied, so from wins conflicts
+func syncCounter(from, to string, counter dirCounter, dryRun bool) ([]syncChange, error) {
+	var changes []syncChange
+	change := func(what string) {
+		changes = append(changes, syncChange{Dir: to, Name: counter.Name, What: what})
+	}
+	src, dst := filepath.Join(from, counter.File), filepath.Join(to, counter.File)
+	srcMeta, srcErr := readMeta(src)
+	if srcErr != nil {
+		return nil, srcErr
+	}
+	dstMeta, dstErr := readMeta(dst)
+	if dstErr != nil {
+		return nil, dstErr
+	}
+	_, statErr := os.Stat(metaPath(src))
+	hasMeta := statErr == nil
+
+	_, dstErr = os.Stat(dst)
+	if srcMeta.Type == TypeUnique && (dstMeta.Type == TypeUnique || dstErr != nil) {
+		result, err := mergeUniqueFile(src, to, counter, dryRun)
+		if err != nil {
+			return changes, err
+		}
+		if result.Changed {
+			change(fmt.Sprintf("%d -> %d", result.Old, result.New))
+		}
+	} else if srcMeta.typed() || dstMeta.typed() {
+		what, changed, err := replaceCounter(src, dst, srcMeta, counter.Name, dryRun)
+		if err != nil {
+			return changes, err
+		}
+		if changed {
+			change(what)
+		}
+	} else {
+		if hasMeta && !sameMeta(srcMeta, dstMeta) {
+			change("metadata updated")
+			if !dryRun {
+				err := editMeta(dst, func(meta *counterMeta) error {
+					*meta = srcMeta
+					return nil
+				})
+				if err != nil {
+					return changes, err
+				}
+			}
+		}
+		remote, readErr := readPN(src)
+		if readErr != nil {
+			return changes, readErr
+		}
+		result, mergeErr := mergeCounter(dst, counter.Name, counter.Named, remote, dryRun)
+		if mergeErr != nil {
+			return changes, mergeErr
+		}
+		if result.Changed {
+			change(fmt.Sprintf("%d -> %d", result.Old, result.New))
+		}
+	}
+
+	floor, floorErr := readTombstone(src)
+	if floorErr != nil {
+		return changes, floorErr
+	}
+	if current, err := readTombstone(dst); err == nil && floor > current {
+		change("tombstone " + strconv.FormatInt(floor, 10))
+		if !dryRun {
+			if err := writeTombstone(dst, floor); err != nil {
+				return changes, err
+			}
+		}
+	}
+	if counter.Named && !dryRun {
+		if err := recordName(dst, counter.Name); err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// syncDir brings every counter of the from directory into the to directory; hooks and webhooks run as
+// configured in the to directory, while the hook and webhook registries themselves are never copied
+func syncDir(from, to string, dryRun bool) ([]syncChange, error) {
+	counters, listErr := dirCounters(from)
+	if listErr != nil {
+		return nil, listErr
+	}
+	var changes []syncChange
+	for _, counter := range counters {
+		counterChanges, err := syncCounter(from, to, counter, dryRun)
+		changes = append(changes, counterChanges...)
+		if err != nil {
+			return changes, fmt.Errorf("counter %s: %w", counter.Name, err)
+		}
+	}
+	return changes, nil
+}
+
+// runSync reconciles the counters, names and metadata of two counter directories
+func runSync(args []string) error {
+	var (
+		from          string
+		to            string
+		bidirectional bool
+		dryRun        bool
+		asJSON        bool
+	)
+	fs := newCommandFlags("sync")
+	fs.StringVar(&from, "from", "", "counter directory to read from")
+	fs.StringVar(&to, "to", "", "counter directory to update (default the counter directory)")
+	fs.BoolVar(&bidirectional, "bidirectional", false, "also bring the counters of -to into -from")
+	fs.BoolVar(&dryRun, "dry-run", false, "show what would change without writing")
+	fs.BoolVar(&asJSON, "json", false, "print the changes as newline delimited JSON")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 0 || from == "" {
+		return errors.New("usage: counter sync -from <dir> [-to <dir>] [-bidirectional] [-dry-run] [-json]")
+	}
+	if to != "" {
+		counterDir = to
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	to = counterDir
+	resolved, resolveErr := filepath.EvalSymlinks(from)
+	if resolveErr != nil {
+		return resolveErr
+	}
+	from, _ = filepath.Abs(resolved)
+	if abs, err := filepath.Abs(to); err == nil && abs == from {
+		return errors.New("cannot sync a counter directory with itself")
+	}
+
+	changes, syncErr := syncDir(from, to, dryRun)
+	if syncErr == nil && bidirectional {
+		var back []syncChange
+		back, syncErr = syncDir(to, from, dryRun)
+		changes = append(changes, back...)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	for _, change := range changes {
+		if asJSON {
+			_ = encoder.Encode(change)
+		} else {
+			fmt.Println(change)
+		}
+	}
+	return syncErr
+}
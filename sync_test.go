@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSyncDir tests that a bidirectional sync reconciles values, metadata, names and tombstones and is idempotent
+func TestSyncDir(t *testing.T) {
+	a := useCounterDir(t)
+	useNode(t, "a")
+	if _, _, err := updateCounter("jobs", func(current int64) (int64, error) { return current + 4, nil }); err != nil {
+		t.Fatalf("updateCounter failed: %v", err)
+	}
+	if err := editMeta(counterPath("jobs"), func(meta *counterMeta) error {
+		meta.Schedule = &resetSchedule{Every: "month"}
+		return nil
+	}); err != nil {
+		t.Fatalf("editMeta failed: %v", err)
+	}
+	if _, err := convertType(counterPath("cash"), counterMeta{Type: TypeDecimal, Scale: 2}); err != nil {
+		t.Fatalf("convertType failed: %v", err)
+	}
+	if _, err := addTyped("cash", "1.25"); err != nil {
+		t.Fatalf("addTyped failed: %v", err)
+	}
+	if err := writeTombstone(counterPath("invoices"), 41); err != nil {
+		t.Fatalf("writeTombstone failed: %v", err)
+	}
+	if err := recordName(counterPath("invoices"), "invoices"); err != nil {
+		t.Fatalf("recordName failed: %v", err)
+	}
+
+	b := useCounterDir(t)
+	useNode(t, "b")
+	if _, _, err := updateCounter("jobs", func(current int64) (int64, error) { return current + 2, nil }); err != nil {
+		t.Fatalf("updateCounter failed: %v", err)
+	}
+
+	dryRun, err := syncDir(a, b, true)
+	if err != nil || len(dryRun) == 0 {
+		t.Fatalf("Expected a dry run to report changes, got %v (%v)", dryRun, err)
+	}
+	if value, _ := readTyped(counterPath("cash")); value != "0" {
+		t.Errorf("Expected a dry run to leave cash alone, got %s", value)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := syncDir(a, b, false); err != nil {
+			t.Fatalf("syncDir failed: %v", err)
+		}
+		if _, err := syncDir(b, a, false); err != nil {
+			t.Fatalf("syncDir failed: %v", err)
+		}
+	}
+	if changes, _ := syncDir(a, b, false); len(changes) != 0 {
+		t.Errorf("Expected a repeated sync to change nothing, got %v", changes)
+	}
+
+	for _, dir := range []string{a, b} {
+		counterDir = dir
+		if value, _ := readCounter(counterPath("jobs")); value != 6 {
+			t.Errorf("Expected jobs to be 6 in %s, got %d", dir, value)
+		}
+		if value, _ := readTyped(counterPath("cash")); value != "1.25" {
+			t.Errorf("Expected cash to be 1.25 in %s, got %s", dir, value)
+		}
+		if meta, _ := readMeta(counterPath("jobs")); meta.Schedule == nil {
+			t.Errorf("Expected jobs to keep its schedule in %s", dir)
+		}
+		names, _ := loadNames(dir)
+		if names[filepath.Base(counterPath("cash"))] != "cash" {
+			t.Errorf("Expected cash in the names manifest of %s", dir)
+		}
+	}
+	counterDir = b
+	if floor, _ := readTombstone(filepath.Join(b, generateCounterFileName("invoices"))); floor != 41 {
+		t.Errorf("Expected the tombstone to be synced, got %d", floor)
+	}
+}
+
+// TestSyncDirRunsTargetHooks tests that a sync runs the hooks of the directory it changes without touching
+// the counter directory
+func TestSyncDirRunsTargetHooks(t *testing.T) {
+	a := useCounterDir(t)
+	if _, _, err := updateCounter("jobs", func(current int64) (int64, error) { return current + 3, nil }); err != nil {
+		t.Fatalf("updateCounter failed: %v", err)
+	}
+	b := useCounterDir(t)
+	out := filepath.Join(t.TempDir(), "out")
+	if err := editHooks(func(hooks []hook) ([]hook, error) {
+		return append(hooks, hook{ID: 1, Counter: "jobs", When: "changed", Exec: "echo $COUNTER_NEW > " + out}), nil
+	}); err != nil {
+		t.Fatalf("editHooks failed: %v", err)
+	}
+
+	elsewhere := useCounterDir(t)
+	if _, err := syncDir(a, b, false); err != nil {
+		t.Fatalf("syncDir failed: %v", err)
+	}
+	if counterDir != elsewhere {
+		t.Errorf("Expected the counter directory to stay %s, got %s", elsewhere, counterDir)
+	}
+	if data, err := os.ReadFile(out); err != nil || string(data) != "3\n" {
+		t.Errorf("Expected the hook of the target to see 3, got %q (%v)", data, err)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// terminalSize is the size of a terminal window as reported by TIOCGWINSZ
+type terminalSize struct {
+	Rows, Cols, X, Y uint16
+}
+
+// ioctl runs an ioctl request on the terminal behind f
+func ioctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, uintptr(arg)); errno != 0 {
+		return os.NewSyscallError("ioctl", errno)
+	}
+	return nil
+}
+
+// rawTerminal turns off line buffering and echo on the terminal behind f so that keys are read as they are
+// pressed, and returns the function that restores the previous settings
+func rawTerminal(f *os.File) (func(), error) {
+	var saved syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&saved)); err != nil {
+		return nil, err
+	}
+	raw := saved
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN], raw.Cc[syscall.VTIME] = 1, 0
+	if err := ioctl(f, syscall.TCSETS, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return func() {
+		_ = ioctl(f, syscall.TCSETS, unsafe.Pointer(&saved))
+	}, nil
+}
+
+// windowSize returns the number of columns and rows of the terminal behind f
+func windowSize(f *os.File) (cols, rows int, err error) {
+	var size terminalSize
+	if err := ioctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil {
+		return 0, 0, err
+	}
+	return int(size.Cols), int(size.Rows), nil
+}
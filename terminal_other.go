@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errTerminalUnsupported is returned where raw terminal input is not implemented
+var errTerminalUnsupported = errors.New("the interactive dashboard is not supported on this platform")
+
+// rawTerminal always fails on platforms without termios support
+func rawTerminal(f *os.File) (func(), error) {
+	return nil, errTerminalUnsupported
+}
+
+// windowSize always fails on platforms without termios support
+func windowSize(f *os.File) (cols, rows int, err error) {
+	return 0, 0, errTerminalUnsupported
+}
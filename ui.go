@@ -0,0 +1,527 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DefaultUISort     string        = "rate"
+	DefaultUIInterval time.Duration = time.Second
+	DefaultUIRefresh  time.Duration = 5 * time.Second
+	DefaultUIDebounce time.Duration = 100 * time.Millisecond
+	SparklineWidth    int           = 20
+)
+
+// uiSorts are the orders the dashboard cycles through with s
+var uiSorts = []string{"rate", "value", "delta", "name"}
+
+// sparkLevels are the bars of a sparkline from no activity to the busiest bucket
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+// dashboardRow is a counter as the dashboard shows it
+type dashboardRow struct {
+	Entry   indexEntry
+	Delta   *big.Rat
+	Rate    float64
+	History []historyEntry
+}
+
+// dashboard is the state of counter ui: the counters it shows, the selected one and any input in progress
+type dashboard struct {
+	Pattern  string
+	Sort     string
+	Over     time.Duration
+	Rows     []dashboardRow
+	Selected int
+	Status   string
+	start    map[string]*big.Rat
+	started  bool
+	editing  bool
+	input    string
+	confirm  bool
+}
+
+// newDashboard returns a dashboard of the counters whose name matches a pattern
+func newDashboard(pattern, sortBy string, over time.Duration) (*dashboard, error) {
+	if !slices.Contains(uiSorts, sortBy) {
+		return nil, fmt.Errorf("invalid sort %q: expected %s", sortBy, strings.Join(uiSorts, ", "))
+	}
+	if over <= 0 {
+		return nil, errors.New("-over must be positive")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return &dashboard{Pattern: pattern, Sort: sortBy, Over: over, start: make(map[string]*big.Rat)}, nil
+}
+
+// selected returns the selected row, or nil when no counter matches
+func (d *dashboard) selected() *dashboardRow {
+	if d.Selected < 0 || d.Selected >= len(d.Rows) {
+		return nil
+	}
+	return &d.Rows[d.Selected]
+}
+
+// refresh reads the matching counters and their history again, keeping the same counter selected; the
+// values seen on the first refresh are the start that deltas are measured from, and counters created
+// later start from 0
+func (d *dashboard) refresh() error {
+	entries, indexErr := nameIndex(func(name string) bool { return matchName(d.Pattern, name) })
+	if indexErr != nil {
+		return indexErr
+	}
+	selectedName := ""
+	if row := d.selected(); row != nil {
+		selectedName = row.Entry.Name
+	}
+	rows := make([]dashboardRow, 0, len(entries))
+	for _, entry := range entries {
+		value, err := parseNumber(entry.Value)
+		if err != nil {
+			value = new(big.Rat)
+		}
+		start, ok := d.start[entry.Name]
+		if !ok {
+			start = new(big.Rat)
+			if !d.started {
+				start = value
+			}
+			d.start[entry.Name] = start
+		}
+		history, historyErr := readHistory(entry.Path)
+		if historyErr != nil {
+			return fmt.Errorf("counter %s: %w", entry.Name, historyErr)
+		}
+		rows = append(rows, dashboardRow{
+			Entry:   entry,
+			Delta:   new(big.Rat).Sub(value, start),
+			Rate:    increase(history, d.Over) / d.Over.Seconds(),
+			History: history,
+		})
+	}
+	d.started = true
+	d.Rows = rows
+	d.sortRows()
+	d.reselect(selectedName)
+	return nil
+}
+
+// reselect selects the row of the named counter, or the first row when it is gone
+func (d *dashboard) reselect(name string) {
+	d.Selected = 0
+	for i, row := range d.Rows {
+		if row.Entry.Name == name {
+			d.Selected = i
+		}
+	}
+}
+
+// sortRows orders the rows by name, or by rate, value or delta with the highest first
+func (d *dashboard) sortRows() {
+	value := func(row dashboardRow) float64 {
+		parsed, err := parseNumber(row.Entry.Value)
+		if err != nil {
+			return 0
+		}
+		f, _ := parsed.Float64()
+		return f
+	}
+	sort.SliceStable(d.Rows, func(i, j int) bool {
+		a, b := d.Rows[i], d.Rows[j]
+		switch d.Sort {
+		case "rate":
+			if a.Rate != b.Rate {
+				return a.Rate > b.Rate
+			}
+		case "value":
+			if va, vb := value(a), value(b); va != vb {
+				return va > vb
+			}
+		case "delta":
+			if cmp := a.Delta.Cmp(b.Delta); cmp != 0 {
+				return cmp > 0
+			}
+		}
+		return a.Entry.Name < b.Entry.Name
+	})
+}
+
+// sparkline draws the increases recorded over the last span as width bars, one per equal slice of the span
+func sparkline(history []historyEntry, span time.Duration, width int) string {
+	buckets := make([]float64, width)
+	end := now()
+	since := end.Add(-span)
+	peak := 0.0
+	for _, entry := range history {
+		if !entry.At.After(since) || entry.At.After(end) || entry.Value <= entry.Previous {
+			continue
+		}
+		i := min(int(float64(entry.At.Sub(since))/float64(span)*float64(width)), width-1)
+		buckets[i] += float64(entry.Value) - float64(entry.Previous)
+		peak = max(peak, buckets[i])
+	}
+	bars := make([]rune, width)
+	for i, amount := range buckets {
+		level := 0
+		if amount > 0 {
+			level = int(math.Ceil(amount / peak * float64(len(sparkLevels)-1)))
+		}
+		bars[i] = sparkLevels[level]
+	}
+	return string(bars)
+}
+
+// formatDelta formats a change since the dashboard started with its sign
+func formatDelta(delta *big.Rat) string {
+	text := delta.RatString()
+	if !delta.IsInt() {
+		f, _ := delta.Float64()
+		text = formatFloat(math.Round(f*1000) / 1000)
+	}
+	if delta.Sign() > 0 {
+		return "+" + text
+	}
+	return text
+}
+
+// clip cuts text to at most width characters
+func clip(text string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= width {
+		return text
+	}
+	return string([]rune(text)[:width])
+}
+
+// render draws the dashboard into a terminal of the given size
+func (d *dashboard) render(w io.Writer, cols, rows int) error {
+	nameWidth := len("NAME")
+	for _, row := range d.Rows {
+		nameWidth = max(nameWidth, utf8.RuneCountInString(row.Entry.Name))
+	}
+	nameWidth = min(nameWidth, max(cols/3, len("NAME")))
+	line := func(name, value, delta, rate, spark string) string {
+		return fmt.Sprintf("%-*s %14s %12s %12s  %s", nameWidth, clip(name, nameWidth), value, delta, rate, spark)
+	}
+
+	lines := []string{
+		fmt.Sprintf("counter ui  %s  pattern %s  sort %s  over %s  %d counters", counterDir, d.Pattern, d.Sort, d.Over, len(d.Rows)),
+		line("NAME", "VALUE", "DELTA", "RATE", "HISTORY"),
+	}
+	// keep the selected row in view when there are more counters than rows
+	visible := max(rows-len(lines)-2, 1)
+	first := max(d.Selected-visible+1, 0)
+	for i := first; i < len(d.Rows) && i < first+visible; i++ {
+		row := d.Rows[i]
+		text := clip(line(row.Entry.Name, row.Entry.Value, formatDelta(row.Delta), formatRate(roundRate(row.Rate)), sparkline(row.History, d.Over, SparklineWidth)), cols)
+		if i == d.Selected {
+			text = "\x1b[7m" + text + "\x1b[0m"
+		}
+		lines = append(lines, text)
+	}
+	for len(lines) < rows-2 {
+		lines = append(lines, "")
+	}
+	switch {
+	case d.editing:
+		lines = append(lines, clip("filter: "+d.input, cols))
+	case d.Status != "":
+		lines = append(lines, clip(d.Status, cols))
+	default:
+		lines = append(lines, "")
+	}
+	lines = append(lines, clip("up/down select  + add  - sub  r reset  s sort  / filter  q quit", cols))
+	_, err := io.WriteString(w, "\x1b[H\x1b[2J"+strings.Join(lines, "\n"))
+	return err
+}
+
+// stepEntry adds 1 to a counter of any type, or subtracts 1 when negate is set
+func stepEntry(entry indexEntry, negate bool) error {
+	if !negate && neverAdd {
+		return errors.New("add operation is disabled by the environment variable")
+	}
+	if negate && neverSubtract {
+		return errors.New("subtract operation is disabled by the environment variable")
+	}
+	if entry.Meta.typed() {
+		_, err := editTyped(entry.Name, func(meta counterMeta, current string) (string, error) {
+			return meta.combine(current, "1", negate)
+		})
+		return err
+	}
+	step := int64(1)
+	if negate {
+		step = -1
+	}
+	_, _, err := updateCounter(entry.Name, func(current int64) (int64, error) {
+		return addClamped(current, step), nil
+	})
+	return err
+}
+
+// handle applies a key to the dashboard and reports whether the dashboard should close
+func (d *dashboard) handle(key string) bool {
+	if d.editing {
+		switch key {
+		case "enter":
+			pattern := d.input
+			if pattern == "" {
+				pattern = "*"
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				d.Status = fmt.Sprintf("Error: invalid pattern %q", pattern)
+			} else {
+				d.Pattern = pattern
+			}
+			d.editing = false
+		case "esc":
+			d.editing = false
+		case "backspace":
+			if runes := []rune(d.input); len(runes) > 0 {
+				d.input = string(runes[:len(runes)-1])
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				d.input += key
+			}
+		}
+		return false
+	}
+	if d.confirm {
+		d.confirm = false
+		row := d.selected()
+		if key != "y" || row == nil {
+			d.Status = "reset cancelled"
+			return false
+		}
+		d.act(fmt.Sprintf("counter %s reset", row.Entry.Name), resetEntry(row.Entry))
+		return false
+	}
+
+	d.Status = ""
+	switch key {
+	case "q", "ctrl-c":
+		return true
+	case "up", "k":
+		d.Selected = max(d.Selected-1, 0)
+	case "down", "j":
+		d.Selected = max(min(d.Selected+1, len(d.Rows)-1), 0)
+	case "s":
+		d.Sort = uiSorts[(slices.Index(uiSorts, d.Sort)+1)%len(uiSorts)]
+		if row := d.selected(); row != nil {
+			name := row.Entry.Name
+			d.sortRows()
+			d.reselect(name)
+		}
+	case "/":
+		d.editing, d.input = true, d.Pattern
+	case "+", "-":
+		if row := d.selected(); row != nil {
+			d.act("", stepEntry(row.Entry, key == "-"))
+		}
+	case "r":
+		row := d.selected()
+		switch {
+		case row == nil:
+		case neverReset:
+			d.Status = "Error: reset operation is disabled by the environment variable"
+		case row.Entry.Meta.Type == TypeDerived:
+			d.Status = "Error: derived counters follow the counters they are computed from"
+		case useYes:
+			d.act(fmt.Sprintf("counter %s reset", row.Entry.Name), resetEntry(row.Entry))
+		default:
+			d.confirm = true
+			d.Status = fmt.Sprintf("reset %s to 0? y/N", row.Entry.Name)
+		}
+	}
+	return false
+}
+
+// act shows the outcome of an action on the selected counter in the status line
+func (d *dashboard) act(done string, err error) {
+	if err != nil {
+		d.Status = fmt.Sprintf("Error: counter %s: %v", d.selected().Entry.Name, err)
+		return
+	}
+	d.Status = done
+}
+
+// parseKeys splits the bytes read from a terminal into key names: arrows, enter, esc, backspace,
+// ctrl-c or the character typed
+func parseKeys(input []byte) []string {
+	var keys []string
+	for len(input) > 0 {
+		switch {
+		case len(input) >= 3 && input[0] == 0x1b && input[1] == '[':
+			switch input[2] {
+			case 'A':
+				keys = append(keys, "up")
+			case 'B':
+				keys = append(keys, "down")
+			}
+			input = input[3:]
+			continue
+		case input[0] == 0x1b:
+			keys = append(keys, "esc")
+		case input[0] == '\r' || input[0] == '\n':
+			keys = append(keys, "enter")
+		case input[0] == 0x7f || input[0] == 0x08:
+			keys = append(keys, "backspace")
+		case input[0] == 0x03:
+			keys = append(keys, "ctrl-c")
+		default:
+			r, size := utf8.DecodeRune(input)
+			if r != utf8.RuneError && r >= ' ' {
+				keys = append(keys, string(r))
+			}
+			input = input[size:]
+			continue
+		}
+		input = input[1:]
+	}
+	return keys
+}
+
+// runUI shows a live dashboard of the counters whose name matches a pattern until q is pressed; it redraws
+// when the counter directory changes, or every interval where filesystem notifications are unavailable
+func runUI(pattern, sortBy string, over, interval time.Duration) error {
+	d, newErr := newDashboard(pattern, sortBy, over)
+	if newErr != nil {
+		return newErr
+	}
+	if err := d.refresh(); err != nil {
+		return err
+	}
+	restore, rawErr := rawTerminal(os.Stdin)
+	if rawErr != nil {
+		return fmt.Errorf("counter ui needs a terminal: %w", rawErr)
+	}
+	defer restore()
+	// the alternate screen keeps the shell's scrollback intact, and the cursor is hidden while drawing
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	draw := func() {
+		cols, rows, err := windowSize(os.Stdout)
+		if err != nil || cols == 0 || rows == 0 {
+			cols, rows = 80, 24
+		}
+		_ = d.render(os.Stdout, cols, rows)
+	}
+	update := func() {
+		if err := d.refresh(); err != nil {
+			d.Status = fmt.Sprintf("Error: %v", err)
+		}
+		draw()
+	}
+
+	keys := make(chan []byte)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGWINCH)
+	defer signal.Stop(signals)
+
+	var events <-chan string
+	if watcher, watchErr := newDirWatcher(counterDir); watchErr == nil {
+		defer watcher.Close()
+		events = watcher.Events
+		// rates and sparklines move with the clock even when nothing changes
+		interval = DefaultUIRefresh
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var debounce <-chan time.Time
+
+	draw()
+	for {
+		select {
+		case input, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, key := range parseKeys(input) {
+				if d.handle(key) {
+					return nil
+				}
+			}
+			update()
+		case file, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			// every read takes a lock, so lock files and temporary files would redraw the dashboard endlessly
+			if strings.HasSuffix(file, ".lock") || strings.Contains(file, ".tmp") {
+				continue
+			}
+			if debounce == nil {
+				debounce = time.After(DefaultUIDebounce)
+			}
+		case <-debounce:
+			debounce = nil
+			update()
+		case <-ticker.C:
+			update()
+		case sig := <-signals:
+			if sig != syscall.SIGWINCH {
+				return nil
+			}
+			draw()
+		}
+	}
+}
+
+// runUIDashboard shows a live dashboard of the counters whose name matches a pattern
+func runUIDashboard(args []string) error {
+	var (
+		sortBy   = DefaultUISort
+		over     = DefaultRateOver
+		interval = DefaultUIInterval
+	)
+	fs := newCommandFlags("ui")
+	fs.StringVar(&sortBy, "sort", sortBy, "sort counters by rate, value, delta or name")
+	fs.DurationVar(&over, "over", over, "span rates and sparklines cover, such as 5m")
+	fs.DurationVar(&interval, "interval", interval, "polling interval when notifications are unavailable")
+	fs.BoolVar(&useYes, "yes", useYes, "reset counters without asking")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) > 1 {
+		return errors.New("usage: counter ui [pattern] [-sort rate|value|delta|name] [-over D]")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+	pattern := "*"
+	if len(positional) == 1 {
+		pattern = positional[0]
+	}
+	return runUI(pattern, sortBy, over, interval)
+}
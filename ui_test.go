@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseKeys tests splitting terminal input into key names
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("j\x1b[A\x1b[B+\r\x7f\x1b\x03é"))
+	want := []string{"j", "up", "down", "+", "enter", "backspace", "esc", "ctrl-c", "é"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// TestSparkline tests that increases land in the bar of their slice of the span and resets are not drawn
+func TestSparkline(t *testing.T) {
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	useClock(t, start.Add(4*time.Minute))
+	history := []historyEntry{
+		{At: start.Add(-time.Hour), Previous: 0, Value: 100},
+		{At: start.Add(30 * time.Second), Previous: 0, Value: 10},
+		{At: start.Add(150 * time.Second), Previous: 10, Value: 15},
+		{At: start.Add(200 * time.Second), Previous: 15, Value: 0},
+	}
+	if got := sparkline(history, 4*time.Minute, 4); got != "█▁▅▁" {
+		t.Errorf("Expected █▁▅▁, got %s", got)
+	}
+}
+
+// TestDashboard tests refreshing, sorting, filtering and changing counters from the dashboard
+func TestDashboard(t *testing.T) {
+	useCounterDir(t)
+	advance := useClock(t, time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	setCounters(t, map[string]int64{"api.a": 5, "api.b": 7, "web": 1})
+	advance(time.Hour)
+
+	d, err := newDashboard("*", "value", 5*time.Minute)
+	if err != nil {
+		t.Fatalf("newDashboard failed: %v", err)
+	}
+	if err := d.refresh(); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	names := func() string {
+		var list []string
+		for _, row := range d.Rows {
+			list = append(list, row.Entry.Name)
+		}
+		return strings.Join(list, ",")
+	}
+	if got := names(); got != "api.b,api.a,web" {
+		t.Errorf("Expected the highest value first, got %s", got)
+	}
+
+	for _, key := range []string{"down", "down", "+", "+", "+"} {
+		d.handle(key)
+	}
+	if err := d.refresh(); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if row := d.selected(); row == nil || row.Entry.Name != "web" || row.Entry.Value != "4" || formatDelta(row.Delta) != "+3" {
+		t.Errorf("Expected web at 4, 3 more than at the start, got %+v", row)
+	}
+	d.handle("s")
+	if d.Sort != "delta" || names() != "web,api.a,api.b" {
+		t.Errorf("Expected the largest delta first, got %s by %s", names(), d.Sort)
+	}
+
+	d.handle("r")
+	d.handle("n")
+	if d.Status != "reset cancelled" || d.selected().Entry.Value != "4" {
+		t.Errorf("Expected the reset to be cancelled, got %q", d.Status)
+	}
+	d.handle("r")
+	d.handle("y")
+	if value, _ := readTyped(counterPath("web")); value != "0" {
+		t.Errorf("Expected web to be reset, got %s", value)
+	}
+	neverReset = true
+	t.Cleanup(func() { neverReset = false })
+	d.handle("r")
+	if !strings.Contains(d.Status, "disabled") {
+		t.Errorf("Expected the reset to be refused, got %q", d.Status)
+	}
+
+	for _, key := range []string{"/", "backspace", "a", "p", "i", "*", "enter"} {
+		d.handle(key)
+	}
+	if err := d.refresh(); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if d.Pattern != "api*" || len(d.Rows) != 2 {
+		t.Errorf("Expected two counters matching api*, got %d matching %s", len(d.Rows), d.Pattern)
+	}
+	var out bytes.Buffer
+	if err := d.render(&out, 100, 10); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "api.a") || strings.Contains(out.String(), "web") {
+		t.Errorf("Expected only api counters to be drawn, got:\n%s", out.String())
+	}
+	if d.handle("q") != true {
+		t.Errorf("Expected q to close the dashboard")
+	}
+	if _, err := newDashboard("*", "size", time.Minute); err == nil {
+		t.Errorf("Expected an unknown sort to be rejected")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultWaitTimeout  time.Duration = 0
+	DefaultWaitInterval time.Duration = 500 * time.Millisecond
+	WaitWatchedInterval time.Duration = 30 * time.Second
+)
+
+// waitCondition describes the value a counter must reach before wait returns
+type waitCondition struct {
+	ge, eq, le *big.Rat
+	changed    bool
+	initial    string
+}
+
+// met reports whether value satisfies every part of the condition
+func (c waitCondition) met(value string) bool {
+	if c.changed && value == c.initial {
+		return false
+	}
+	if c.ge == nil && c.eq == nil && c.le == nil {
+		return true
+	}
+	r, err := parseNumber(value)
+	if err != nil {
+		return false
+	}
+	if c.ge != nil && r.Cmp(c.ge) < 0 {
+		return false
+	}
+	if c.eq != nil && r.Cmp(c.eq) != 0 {
+		return false
+	}
+	if c.le != nil && r.Cmp(c.le) > 0 {
+		return false
+	}
+	return true
+}
+
+// runWait blocks until a counter satisfies a condition and prints its value
+func runWait(args []string) error {
+	var (
+		ge, eq, le string
+		changed    bool
+		timeout    = DefaultWaitTimeout
+		interval   = DefaultWaitInterval
+	)
+	fs := newCommandFlags("wait")
+	fs.StringVar(&ge, "ge", "", "wait until the counter is greater than or equal to value")
+	fs.StringVar(&eq, "eq", "", "wait until the counter equals value")
+	fs.StringVar(&le, "le", "", "wait until the counter is less than or equal to value")
+	fs.BoolVar(&changed, "changed", false, "wait until the counter changes")
+	fs.DurationVar(&timeout, "timeout", timeout, "give up after duration (0 waits forever)")
+	fs.DurationVar(&interval, "interval", interval, "polling interval when notifications are unavailable")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	if len(positional) != 1 {
+		return errors.New("usage: counter wait <name> [-ge N] [-eq N] [-le N] [-changed] [-timeout D]")
+	}
+
+	var cond waitCondition
+	var numberErr error
+	fs.Visit(func(f *flag.Flag) {
+		var bound **big.Rat
+		switch f.Name {
+		case "ge":
+			bound = &cond.ge
+		case "eq":
+			bound = &cond.eq
+		case "le":
+			bound = &cond.le
+		default:
+			return
+		}
+		r, err := parseNumber(f.Value.String())
+		if err != nil {
+			numberErr = fmt.Errorf("-%s: %w", f.Name, err)
+		}
+		*bound = r
+	})
+	if numberErr != nil {
+		return numberErr
+	}
+	cond.changed = changed
+	if cond.ge == nil && cond.eq == nil && cond.le == nil && !cond.changed {
+		return errors.New("one of -ge, -eq, -le or -changed is required")
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	name := positional[0]
+	path := counterPath(name)
+	if cond.changed {
+		initial, readErr := readTyped(path)
+		if readErr != nil {
+			return readErr
+		}
+		cond.initial = initial
+	}
+	value, waitErr := waitForCounter(path, cond, timeout, interval)
+	if waitErr != nil {
+		return fmt.Errorf("counter %s: %w", name, waitErr)
+	}
+	fmt.Println(value)
+	return nil
+}
+
+// waitForCounter re-reads the counter the way get does whenever its file changes until cond is met, so that
+// typed counters are seen as well; it polls every interval when the directory cannot be watched, and
+// otherwise only every WaitWatchedInterval, which catches scheduled resets that change no file
+func waitForCounter(path string, cond waitCondition, timeout, interval time.Duration) (string, error) {
+	var events <-chan string
+	if watcher, watchErr := newDirWatcher(filepath.Dir(path)); watchErr == nil {
+		defer watcher.Close()
+		events = watcher.Events
+		interval = max(interval, WaitWatchedInterval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	base := filepath.Base(path)
+	for {
+		value, readErr := readTyped(path)
+		if readErr != nil && !errors.Is(readErr, strconv.ErrSyntax) {
+			return "", readErr
+		}
+		// a syntax error means the file was caught mid-write, so wait for the next change
+		if readErr == nil && cond.met(value) {
+			return value, nil
+		}
+		for changed := false; !changed; {
+			select {
+			case name, ok := <-events:
+				if !ok {
+					events = nil
+				}
+				changed = name == base
+			case <-ticker.C:
+				changed = true
+			case <-deadline:
+				return value, fmt.Errorf("timed out after %s", timeout)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWaitConditionMet tests the waitCondition.met function
+func TestWaitConditionMet(t *testing.T) {
+	five, ten := big.NewRat(5, 1), big.NewRat(10, 1)
+	tests := []struct {
+		name  string
+		cond  waitCondition
+		value string
+		want  bool
+	}{
+		{"ge below", waitCondition{ge: five}, "4", false},
+		{"ge equal", waitCondition{ge: five}, "5", true},
+		{"ge decimal", waitCondition{ge: five}, "4.99", false},
+		{"eq miss", waitCondition{eq: five}, "6", false},
+		{"eq hit", waitCondition{eq: five}, "5.00", true},
+		{"le above", waitCondition{le: five}, "6", false},
+		{"le below", waitCondition{le: five}, "-1", true},
+		{"range inside", waitCondition{ge: five, le: ten}, "7", true},
+		{"range outside", waitCondition{ge: five, le: ten}, "11", false},
+		{"changed same", waitCondition{changed: true, initial: "3"}, "3", false},
+		{"changed different", waitCondition{changed: true, initial: "3"}, "4", true},
+	}
+	for _, tt := range tests {
+		if got := tt.cond.met(tt.value); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+// TestWaitForCounter tests that waitForCounter returns once another writer reaches the threshold
+func TestWaitForCounter(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "counterFile")
+	threshold := big.NewRat(5, 1)
+	go func() {
+		for i := 1; i <= 5; i++ {
+			time.Sleep(20 * time.Millisecond)
+			_ = os.WriteFile(testFile, []byte{byte('0' + i)}, 0600)
+		}
+	}()
+	value, err := waitForCounter(testFile, waitCondition{ge: threshold}, 5*time.Second, time.Second)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if value != "5" {
+		t.Errorf("Expected 5, got %s", value)
+	}
+}
+
+// TestWaitForCounterTimeout tests that waitForCounter gives up after the timeout
+func TestWaitForCounterTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "counterFile")
+	threshold := big.NewRat(1, 1)
+	value, err := waitForCounter(testFile, waitCondition{eq: threshold}, 50*time.Millisecond, 10*time.Millisecond)
+	if err == nil {
+		t.Fatalf("Expected a timeout error, got value %s", value)
+	}
+}
+
+// TestWaitForTypedCounter tests that waiting reads typed counters the way get does
+func TestWaitForTypedCounter(t *testing.T) {
+	useCounterDir(t)
+	path := counterPath("latency")
+	if err := writeMeta(path, counterMeta{Type: TypeDecimal, Scale: 2}); err != nil {
+		t.Fatalf("failed to write metadata: %v", err)
+	}
+	if _, err := addTyped("latency", "2.75"); err != nil {
+		t.Fatalf("addTyped failed: %v", err)
+	}
+	value, err := waitForCounter(path, waitCondition{ge: big.NewRat(5, 2)}, time.Second, 10*time.Millisecond)
+	if err != nil || value != "2.75" {
+		t.Errorf("Expected 2.75, got %q (%v)", value, err)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultWatchInterval time.Duration = time.Second
+	DefaultWatchJSON     bool          = false
+	DefaultWatchListen   string        = ""
+	WatchChanged         string        = "change"
+	WatchRemoved         string        = "removed"
+)
+
+// counterChange describes a single change to a counter value, or the removal of a counter, which has
+// no new value; values are numbers so that int, big, decimal and float counters are all reported exactly
+type counterChange struct {
+	Event string      `json:"event"`
+	Name  string      `json:"name"`
+	Old   json.Number `json:"old"`
+	New   json.Number `json:"new,omitempty"`
+	Time  time.Time   `json:"time"`
+}
+
+// String formats the change as a single line of text
+func (c counterChange) String() string {
+	if c.Event == WatchRemoved {
+		return fmt.Sprintf("%s %s removed (was %s)", c.Time.Format(time.RFC3339), c.Name, c.Old)
+	}
+	return fmt.Sprintf("%s %s %s -> %s", c.Time.Format(time.RFC3339), c.Name, c.Old, c.New)
+}
+
+// runWatch streams changes to counters whose names match a pattern
+func runWatch(args []string) error {
+	var (
+		asJSON   = DefaultWatchJSON
+		listen   = DefaultWatchListen
+		interval = DefaultWatchInterval
+	)
+	fs := newCommandFlags("watch")
+	fs.BoolVar(&asJSON, "json", asJSON, "emit changes as newline delimited JSON")
+	fs.StringVar(&listen, "listen", listen, "also serve changes as server-sent events on this address at /events")
+	fs.DurationVar(&interval, "interval", interval, "polling interval when notifications are unavailable")
+	positional, parseErr := parseCommandArgs(fs, args)
+	if parseErr != nil {
+		return parseErr
+	}
+	pattern := "*"
+	if len(positional) > 1 {
+		return errors.New("usage: counter watch [pattern] [-json] [-listen addr]")
+	} else if len(positional) == 1 {
+		pattern = positional[0]
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	broker := newChangeBroker()
+	if listen != DefaultWatchListen {
+		mux := http.NewServeMux()
+		mux.Handle("/events", broker)
+		server := &http.Server{Addr: listen, Handler: mux}
+		defer server.Close()
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(done)
+	}()
+
+	encoder := json.NewEncoder(os.Stdout)
+	return watchCounters(counterDir, pattern, interval, done, func(change counterChange) {
+		if asJSON {
+			_ = encoder.Encode(change)
+		} else {
+			fmt.Println(change)
+		}
+		broker.publish(change)
+	})
+}
+
+// watchCounters calls emit for every change to a counter in dir matching pattern until done is closed
+func watchCounters(dir, pattern string, interval time.Duration, done <-chan struct{}, emit func(counterChange)) error {
+	names, namesErr := loadNames(dir)
+	if namesErr != nil {
+		return namesErr
+	}
+	values := make(map[string]string)
+
+	// check reads a counter file and emits a change when its value differs from the last one seen, or a
+	// removal when a counter that was seen no longer exists
+	check := func(file string, announce bool) {
+		name, ok := nameOf(file, names)
+		if !ok || !matchName(pattern, name) {
+			return
+		}
+		if _, err := os.Stat(filepath.Join(dir, file)); errors.Is(err, fs.ErrNotExist) {
+			if old, seen := values[file]; seen {
+				delete(values, file)
+				if announce {
+					emit(counterChange{Event: WatchRemoved, Name: name, Old: json.Number(old), Time: time.Now()})
+				}
+			}
+			return
+		}
+		value, readErr := peekValue(filepath.Join(dir, file))
+		if readErr != nil {
+			// files caught mid-write or that are not counters are skipped
+			return
+		}
+		old, seen := values[file]
+		if seen && old == value {
+			return
+		}
+		values[file] = value
+		if !seen {
+			old = "0"
+		}
+		if announce {
+			emit(counterChange{Event: WatchChanged, Name: name, Old: json.Number(old), New: json.Number(value), Time: time.Now()})
+		}
+	}
+
+	// scan checks every file in the directory along with counters that have since been removed
+	scan := func(announce bool) {
+		entries, _ := os.ReadDir(dir)
+		present := make(map[string]bool, len(entries))
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				present[entry.Name()] = true
+				check(entry.Name(), announce)
+			}
+		}
+		for file := range values {
+			if !present[file] {
+				check(file, announce)
+			}
+		}
+	}
+
+	// reloadNames picks up counters whose names were recorded after their first write
+	reloadNames := func() {
+		reloaded, err := loadNames(dir)
+		if err != nil {
+			return
+		}
+		names = reloaded
+		for file := range names {
+			if _, seen := values[file]; !seen {
+				check(file, true)
+			}
+		}
+	}
+
+	scan(false)
+	var events <-chan string
+	if watcher, watchErr := newDirWatcher(dir); watchErr == nil {
+		defer watcher.Close()
+		events = watcher.Events
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case file, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if file == NamesFile {
+				reloadNames()
+				continue
+			}
+			check(file, true)
+		case <-ticker.C:
+			if events == nil {
+				reloadNames()
+				scan(true)
+			}
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// changeBroker fans counter changes out to server-sent event subscribers
+type changeBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan counterChange]struct{}
+}
+
+// newChangeBroker returns a broker without subscribers
+func newChangeBroker() *changeBroker {
+	return &changeBroker{subscribers: make(map[chan counterChange]struct{})}
+}
+
+// publish delivers change to every subscriber, dropping it for subscribers that are not keeping up
+func (b *changeBroker) publish(change counterChange) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- change:
+		default:
+		}
+	}
+}
+
+// ServeHTTP streams changes to the client as server-sent events until it disconnects
+func (b *changeBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	subscriber := make(chan counterChange, 64)
+	b.mu.Lock()
+	b.subscribers[subscriber] = struct{}{}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.subscribers, subscriber)
+		b.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for id := 1; ; id++ {
+		select {
+		case change := <-subscriber:
+			data, _ := json.Marshal(change)
+			_, _ = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", strconv.Itoa(id), change.Event, data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
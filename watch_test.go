@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWatchCounters tests that watchCounters reports changes to matching counters only, and their removal
+func TestWatchCounters(t *testing.T) {
+	tmpDir := t.TempDir()
+	jobs := filepath.Join(tmpDir, generateCounterFileName("jobs.done"))
+	other := filepath.Join(tmpDir, generateCounterFileName("other"))
+	if err := os.WriteFile(jobs, []byte("1"), 0600); err != nil {
+		t.Fatalf("failed to write counter: %v", err)
+	}
+	if err := recordName(jobs, "jobs.done"); err != nil {
+		t.Fatalf("failed to record name: %v", err)
+	}
+
+	changes := make(chan counterChange, 10)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		_ = watchCounters(tmpDir, "jobs.*", 20*time.Millisecond, done, func(change counterChange) {
+			changes <- change
+		})
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	_ = os.WriteFile(other, []byte("9"), 0600)
+	_ = recordName(other, "other")
+	// replace the file the way an atomic writer would
+	if err := writeFileAtomic(jobs, []byte("2"), 0600); err != nil {
+		t.Fatalf("failed to replace counter: %v", err)
+	}
+
+	select {
+	case change := <-changes:
+		if change.Event != WatchChanged || change.Name != "jobs.done" || change.Old != "1" || change.New != "2" {
+			t.Errorf("Expected jobs.done 1 -> 2, got %v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a change for jobs.done")
+	}
+
+	if err := os.Remove(jobs); err != nil {
+		t.Fatalf("failed to remove counter: %v", err)
+	}
+	select {
+	case change := <-changes:
+		if change.Event != WatchRemoved || change.Name != "jobs.done" || change.Old != "2" || change.New != "" {
+			t.Errorf("Expected jobs.done to be removed, got %v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a removal of jobs.done")
+	}
+	select {
+	case change := <-changes:
+		t.Errorf("Expected no further changes, got %v", change)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestChangeBroker tests that published changes reach server-sent event subscribers
+func TestChangeBroker(t *testing.T) {
+	broker := newChangeBroker()
+	server := httptest.NewServer(broker)
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer response.Body.Close()
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %s", contentType)
+	}
+
+	expected := counterChange{Event: WatchChanged, Name: "jobs.done", Old: "1", New: "2", Time: time.Now().UTC().Truncate(time.Second)}
+	go func() {
+		for i := 0; i < 50; i++ {
+			broker.publish(expected)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var change counterChange
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &change); err != nil {
+			t.Fatalf("invalid event data: %v", err)
+		}
+		if change != expected {
+			t.Errorf("Expected %v, got %v", expected, change)
+		}
+		return
+	}
+	t.Fatalf("Expected an event, got none: %v", scanner.Err())
+}
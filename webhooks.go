@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	WebhooksFile          string        = ".webhooks.json"
+	OutboxDir             string        = ".outbox"
+	DefaultWebhookWhen    string        = "changed"
+	DefaultWebhookTimeout time.Duration = 5 * time.Second
+	WebhookMaxAttempts    int           = 10
+	WebhookMaxBackoff     time.Duration = time.Hour
+	WebhookSignature      string        = "X-Counter-Signature"
+)
+
+// webhook posts a JSON payload to a URL when a counter crosses a condition
+type webhook struct {
+	ID      int    `json:"id"`
+	Counter string `json:"counter"`
+	When    string `json:"when"`
+	URL     string `json:"url"`
+	Secret  string `json:"secret,omitempty"`
+}
+
+// webhookPayload is the JSON body posted for a counter change
+type webhookPayload struct {
+	Counter string    `json:"counter"`
+	Old     int64     `json:"old"`
+	New     int64     `json:"new"`
+	When    string    `json:"when"`
+	Webhook int       `json:"webhook"`
+	Time    time.Time `json:"time"`
+}
+
+// outboxEntry is a pending delivery persisted in the outbox until it succeeds or runs out of attempts
+type outboxEntry struct {
+	Webhook     int             `json:"webhook"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// webhookClient sends webhook deliveries
+var webhookClient = &http.Client{Timeout: DefaultWebhookTimeout}
+
+// loadWebhooks reads the webhooks configured in dir
+func loadWebhooks(dir string) ([]webhook, error) {
+	var webhooks []webhook
+	data, err := os.ReadFile(filepath.Join(dir, WebhooksFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return webhooks, nil
+		}
+		return nil, fmt.Errorf("failed to read webhooks: %w", err)
+	}
+	if err := json.Unmarshal(data, &webhooks); err != nil {
+		return nil, fmt.Errorf("invalid webhooks file: %w", err)
+	}
+	return webhooks, nil
+}
+
+// editWebhooks applies fn to the webhooks in counterDir while holding the webhooks lock and saves the result
+func editWebhooks(fn func([]webhook) ([]webhook, error)) error {
+	path := filepath.Join(counterDir, WebhooksFile)
+	unlock, lockErr := lockFile(path + ".lock")
+	if lockErr != nil {
+		return lockErr
+	}
+	defer unlock()
+	webhooks, loadErr := loadWebhooks(counterDir)
+	if loadErr != nil {
+		return loadErr
+	}
+	webhooks, fnErr := fn(webhooks)
+	if fnErr != nil {
+		return fnErr
+	}
+	data, marshalErr := json.MarshalIndent(webhooks, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// signPayload returns the hex encoded HMAC-SHA512 of body keyed with secret
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// queueWebhooks writes an outbox entry in dir for every webhook of dir whose condition the change crosses
+// and returns how many it wrote
+func queueWebhooks(dir, name string, previous, value int64) (int, error) {
+	if previous == value {
+		return 0, nil
+	}
+	webhooks, loadErr := loadWebhooks(dir)
+	if loadErr != nil {
+		return 0, loadErr
+	}
+	queued := 0
+	for _, w := range webhooks {
+		if !matchName(w.Counter, name) {
+			continue
+		}
+		t, parseErr := parseTrigger(w.When)
+		if parseErr != nil || !t.fires(previous, value) {
+			continue
+		}
+		payload, marshalErr := json.Marshal(webhookPayload{
+			Counter: name, Old: previous, New: value, When: w.When, Webhook: w.ID, Time: time.Now(),
+		})
+		if marshalErr != nil {
+			return queued, marshalErr
+		}
+		if err := writeOutboxEntry(dir, "", outboxEntry{Webhook: w.ID, Payload: payload, NextAttempt: time.Now()}); err != nil {
+			return queued, err
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// startWebhookFlush delivers queued webhooks without making the change that queued them wait for the
+// webhook servers; tests replace it
+var startWebhookFlush = spawnWebhookFlush
+
+// spawnWebhookFlush starts counter webhook flush -retry for the counter directory dir as a background
+// process that outlives this one and keeps retrying failed deliveries
+func spawnWebhookFlush(dir string) error {
+	executable, executableErr := os.Executable()
+	if executableErr != nil {
+		return executableErr
+	}
+	dir, absErr := filepath.Abs(dir)
+	if absErr != nil {
+		return absErr
+	}
+	cmd := exec.Command(executable, "webhook", "flush", "-retry", "-dir", dir)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// writeOutboxEntry saves entry under file in the outbox of dir, choosing a new unique file name when file is empty
+func writeOutboxEntry(dir, file string, entry outboxEntry) error {
+	outbox := filepath.Join(dir, OutboxDir)
+	if err := os.MkdirAll(outbox, 0700); err != nil {
+		return err
+	}
+	if file == "" {
+		file = fmt.Sprintf("%020d-%d-%d.json", time.Now().UnixNano(), os.Getpid(), entry.Webhook)
+	}
+	data, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return writeFileAtomic(filepath.Join(outbox, file), data, 0600)
+}
+
+// outboxFiles lists the entries in the outbox of dir in the order they were queued
+func outboxFiles(dir string) ([]string, error) {
+	entries, readErr := os.ReadDir(filepath.Join(dir, OutboxDir))
+	if readErr != nil {
+		if errors.Is(readErr, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, readErr
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// deliverOutbox attempts every entry in the outbox of dir that is due at the given time and returns how many
+// remain pending; when another process is already delivering, it leaves the entries to that process
+func deliverOutbox(dir string, at time.Time) (int, error) {
+	pending, ok, err := deliverDue(dir, at)
+	if err != nil || ok {
+		return pending, err
+	}
+	files, listErr := outboxFiles(dir)
+	return len(files), listErr
+}
+
+// drainOutbox delivers the outbox of dir until it is empty, sleeping until the next entry is due between
+// passes; entries leave the outbox once delivered or out of attempts. The outbox is checked again after
+// every pass has released the lock, since a flush that could not take the lock leaves its entry to this one.
+func drainOutbox(dir string) error {
+	for {
+		if _, ok, err := deliverDue(dir, now()); err != nil || !ok {
+			return err
+		}
+		next, pending, err := nextOutboxAttempt(dir)
+		if err != nil || !pending {
+			return err
+		}
+		if wait := next.Sub(now()); wait > 0 {
+			webhookSleep(wait)
+		}
+	}
+}
+
+// webhookSleep waits between passes over the outbox; tests replace it
+var webhookSleep = time.Sleep
+
+// nextOutboxAttempt returns when the earliest entry in the outbox of dir is due and whether any entry is
+// pending; entries that cannot be read are due right away so that a pass discards them
+func nextOutboxAttempt(dir string) (time.Time, bool, error) {
+	files, listErr := outboxFiles(dir)
+	if listErr != nil || len(files) == 0 {
+		return time.Time{}, false, listErr
+	}
+	var next time.Time
+	for i, file := range files {
+		var entry outboxEntry
+		data, err := os.ReadFile(filepath.Join(dir, OutboxDir, file))
+		if err == nil {
+			err = json.Unmarshal(data, &entry)
+		}
+		if err != nil {
+			return now(), true, nil
+		}
+		if i == 0 || entry.NextAttempt.Before(next) {
+			next = entry.NextAttempt
+		}
+	}
+	return next, true, nil
+}
+
+// deliverDue attempts the due entries in the outbox of dir while holding the outbox lock, listing them only
+// once the lock is held; ok is false when another process holds the lock
+func deliverDue(dir string, at time.Time) (pending int, ok bool, err error) {
+	unlock, locked, lockErr := tryLockFile(filepath.Join(dir, "."+strings.TrimPrefix(OutboxDir, ".")+".lock"))
+	if lockErr != nil || !locked {
+		return 0, false, lockErr
+	}
+	defer unlock()
+	files, listErr := outboxFiles(dir)
+	if listErr != nil || len(files) == 0 {
+		return 0, true, listErr
+	}
+	outbox := filepath.Join(dir, OutboxDir)
+
+	webhooks, loadErr := loadWebhooks(dir)
+	if loadErr != nil {
+		return len(files), true, loadErr
+	}
+	byID := make(map[int]webhook, len(webhooks))
+	for _, w := range webhooks {
+		byID[w.ID] = w
+	}
+
+	for _, file := range files {
+		path := filepath.Join(outbox, file)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry outboxEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			_ = auditLog(dir, "webhook outbox entry %s is invalid and was discarded: %v", file, err)
+			_ = os.Remove(path)
+			continue
+		}
+		w, exists := byID[entry.Webhook]
+		if !exists {
+			_ = auditLog(dir, "webhook %d no longer exists; discarded delivery %s", entry.Webhook, file)
+			_ = os.Remove(path)
+			continue
+		}
+		if entry.NextAttempt.After(at) {
+			pending++
+			continue
+		}
+		sendErr := sendWebhook(w, entry.Payload)
+		entry.Attempts++
+		if sendErr == nil {
+			_ = auditLog(dir, "webhook %d delivered %s to %s after %d attempt(s)", w.ID, file, w.URL, entry.Attempts)
+			_ = os.Remove(path)
+			continue
+		}
+		if entry.Attempts >= WebhookMaxAttempts {
+			_ = auditLog(dir, "webhook %d gave up on %s after %d attempts: %v", w.ID, file, entry.Attempts, sendErr)
+			_ = os.Remove(path)
+			continue
+		}
+		entry.LastError = sendErr.Error()
+		entry.NextAttempt = at.Add(webhookBackoff(entry.Attempts))
+		if err := writeOutboxEntry(dir, file, entry); err != nil {
+			return pending, true, err
+		}
+		pending++
+	}
+	return pending, true, nil
+}
+
+// webhookBackoff returns how long to wait before the next attempt after the given number of failures
+func webhookBackoff(attempts int) time.Duration {
+	backoff := time.Second << uint(attempts-1)
+	if attempts > 12 || backoff > WebhookMaxBackoff {
+		return WebhookMaxBackoff
+	}
+	return backoff
+}
+
+// sendWebhook posts payload to the webhook URL, signing it when the webhook has a secret
+func sendWebhook(w webhook, payload []byte) error {
+	request, requestErr := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(payload))
+	if requestErr != nil {
+		return requestErr
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "counter/"+VERSION)
+	if w.Secret != "" {
+		request.Header.Set(WebhookSignature, "sha512="+signPayload(w.Secret, payload))
+	}
+	response, doErr := webhookClient.Do(request)
+	if doErr != nil {
+		return doErr
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+// runWebhookCommand manages the webhooks attached to counters and delivers pending notifications
+func runWebhookCommand(args []string) error {
+	usage := errors.New("usage: counter webhook add <name> -url <url> [-secret S] [-when condition] | list [pattern] | remove <id> | flush [-retry]")
+	if len(args) == 0 {
+		return usage
+	}
+	var (
+		url    string
+		secret string
+		when   = DefaultWebhookWhen
+		retry  bool
+	)
+	fs := newCommandFlags("webhook " + args[0])
+	if args[0] == "add" {
+		fs.StringVar(&url, "url", "", "URL that receives the JSON payload")
+		fs.StringVar(&secret, "secret", "", "key used to sign payloads with HMAC-SHA512")
+		fs.StringVar(&when, "when", when, "condition such as 'changed', '>= 100' or 'every 1000'")
+	}
+	if args[0] == "flush" {
+		fs.BoolVar(&retry, "retry", false, "keep retrying failed deliveries with backoff until the outbox is empty")
+	}
+	positional, parseErr := parseCommandArgs(fs, args[1:])
+	if parseErr != nil {
+		return parseErr
+	}
+	if err := prepareCounterDir(); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		if len(positional) != 1 || url == "" {
+			return usage
+		}
+		if _, err := parseTrigger(when); err != nil {
+			return err
+		}
+		w := webhook{ID: 1, Counter: positional[0], When: when, URL: url, Secret: secret}
+		editErr := editWebhooks(func(webhooks []webhook) ([]webhook, error) {
+			for _, existing := range webhooks {
+				if existing.ID >= w.ID {
+					w.ID = existing.ID + 1
+				}
+			}
+			return append(webhooks, w), nil
+		})
+		if editErr != nil {
+			return editErr
+		}
+		fmt.Printf("webhook %d added to %s\n", w.ID, w.Counter)
+		return nil
+	case "list":
+		pattern := "*"
+		if len(positional) == 1 {
+			pattern = positional[0]
+		}
+		webhooks, loadErr := loadWebhooks(counterDir)
+		if loadErr != nil {
+			return loadErr
+		}
+		for _, w := range webhooks {
+			if matchName(pattern, w.Counter) || w.Counter == pattern {
+				fmt.Printf("%d\t%s\t%s\t%s\tsigned=%v\n", w.ID, w.Counter, w.When, w.URL, w.Secret != "")
+			}
+		}
+		return nil
+	case "remove":
+		if len(positional) != 1 {
+			return usage
+		}
+		id, idErr := strconv.Atoi(positional[0])
+		if idErr != nil {
+			return fmt.Errorf("invalid webhook id %q", positional[0])
+		}
+		editErr := editWebhooks(func(webhooks []webhook) ([]webhook, error) {
+			for i, w := range webhooks {
+				if w.ID == id {
+					return append(webhooks[:i], webhooks[i+1:]...), nil
+				}
+			}
+			return nil, fmt.Errorf("webhook %d does not exist", id)
+		})
+		if editErr != nil {
+			return editErr
+		}
+		fmt.Printf("webhook %d removed\n", id)
+		return nil
+	case "flush":
+		if retry {
+			return drainOutbox(counterDir)
+		}
+		pending, deliverErr := deliverOutbox(counterDir, time.Now())
+		if deliverErr != nil {
+			return deliverErr
+		}
+		fmt.Printf("%d webhook deliveries pending\n", pending)
+		return nil
+	}
+	return usage
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestWebhookDelivery tests that a crossing posts a signed payload to the webhook URL
+func TestWebhookDelivery(t *testing.T) {
+	useCounterDir(t)
+	received := make(chan webhookPayload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if signature := r.Header.Get(WebhookSignature); signature != "sha512="+signPayload("s3cret", body) {
+			t.Errorf("Expected a valid signature, got %q", signature)
+		}
+		var payload webhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		received <- payload
+	}))
+	defer server.Close()
+
+	err := editWebhooks(func(webhooks []webhook) ([]webhook, error) {
+		return append(webhooks, webhook{ID: 1, Counter: "errors", When: ">= 2", URL: server.URL, Secret: "s3cret"}), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to add webhook: %v", err)
+	}
+	// the flush runs in place of the background process so that the delivery can be checked
+	useWebhookFlush(t, func(dir string) error {
+		_, err := deliverOutbox(dir, time.Now())
+		return err
+	})
+	for i := 0; i < 3; i++ {
+		if _, _, err := updateCounter("errors", func(current int64) (int64, error) { return current + 1, nil }); err != nil {
+			t.Fatalf("failed to update counter: %v", err)
+		}
+	}
+
+	select {
+	case payload := <-received:
+		if payload.Counter != "errors" || payload.Old != 1 || payload.New != 2 || payload.Webhook != 1 {
+			t.Errorf("Unexpected payload %+v", payload)
+		}
+	default:
+		t.Fatalf("Expected the webhook to be delivered")
+	}
+	if len(received) != 0 {
+		t.Errorf("Expected a single delivery")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(counterDir, OutboxDir)); len(entries) != 0 {
+		t.Errorf("Expected an empty outbox, got %d entries", len(entries))
+	}
+}
+
+// TestWebhookRetry tests that failed deliveries stay in the outbox and are retried after the backoff
+func TestWebhookRetry(t *testing.T) {
+	useCounterDir(t)
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	err := editWebhooks(func(webhooks []webhook) ([]webhook, error) {
+		return append(webhooks, webhook{ID: 1, Counter: "*", When: "changed", URL: server.URL}), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to add webhook: %v", err)
+	}
+	flushes := 0
+	useWebhookFlush(t, func(string) error {
+		flushes++
+		return nil
+	})
+	if _, _, err := updateCounter("jobs", func(current int64) (int64, error) { return 5, nil }); err != nil {
+		t.Fatalf("failed to update counter: %v", err)
+	}
+	if flushes != 1 || calls.Load() != 0 {
+		t.Fatalf("Expected the change to queue the delivery and start one flush, got flushes=%d calls=%d", flushes, calls.Load())
+	}
+
+	pending, deliverErr := deliverOutbox(counterDir, time.Now())
+	if deliverErr != nil || pending != 1 || calls.Load() != 1 {
+		t.Fatalf("Expected the first attempt to fail, got pending=%d calls=%d err=%v", pending, calls.Load(), deliverErr)
+	}
+	pending, deliverErr = deliverOutbox(counterDir, time.Now())
+	if deliverErr != nil || pending != 1 || calls.Load() != 1 {
+		t.Fatalf("Expected the retry to wait for its backoff, got pending=%d calls=%d err=%v", pending, calls.Load(), deliverErr)
+	}
+	pending, deliverErr = deliverOutbox(counterDir, time.Now().Add(time.Minute))
+	if deliverErr != nil || pending != 0 || calls.Load() != 2 {
+		t.Fatalf("Expected the retry to succeed, got pending=%d calls=%d err=%v", pending, calls.Load(), deliverErr)
+	}
+}
+
+// useWebhookFlush replaces the background webhook flush for the duration of a test
+func useWebhookFlush(t *testing.T, flush func(dir string) error) {
+	t.Helper()
+	previous := startWebhookFlush
+	startWebhookFlush = flush
+	t.Cleanup(func() { startWebhookFlush = previous })
+}
+
+// TestWebhookBackoff tests the webhookBackoff function
+func TestWebhookBackoff(t *testing.T) {
+	if got := webhookBackoff(1); got != time.Second {
+		t.Errorf("Expected 1s, got %s", got)
+	}
+	if got := webhookBackoff(4); got != 8*time.Second {
+		t.Errorf("Expected 8s, got %s", got)
+	}
+	if got := webhookBackoff(40); got != WebhookMaxBackoff {
+		t.Errorf("Expected %s, got %s", WebhookMaxBackoff, got)
+	}
+}
+
+// TestDrainOutbox tests that a flush keeps retrying failed deliveries until the outbox is empty
+func TestDrainOutbox(t *testing.T) {
+	useCounterDir(t)
+	advance := useClock(t, time.Now())
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	err := editWebhooks(func(webhooks []webhook) ([]webhook, error) {
+		return append(webhooks, webhook{ID: 1, Counter: "jobs", When: "changed", URL: server.URL}), nil
+	})
+	if err != nil {
+		t.Fatalf("failed to add webhook: %v", err)
+	}
+	useWebhookFlush(t, func(string) error { return nil })
+	if _, _, err := updateCounter("jobs", func(current int64) (int64, error) { return 5, nil }); err != nil {
+		t.Fatalf("failed to update counter: %v", err)
+	}
+
+	var slept time.Duration
+	previous := webhookSleep
+	webhookSleep = func(d time.Duration) {
+		slept += d
+		advance(d)
+	}
+	t.Cleanup(func() { webhookSleep = previous })
+	if err := drainOutbox(counterDir); err != nil {
+		t.Fatalf("drainOutbox failed: %v", err)
+	}
+	if calls.Load() != 3 || slept < 3*time.Second {
+		t.Errorf("Expected two retries after backing off, got calls=%d slept=%s", calls.Load(), slept)
+	}
+	if files, _ := outboxFiles(counterDir); len(files) != 0 {
+		t.Errorf("Expected an empty outbox, got %v", files)
+	}
+}
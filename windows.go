@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"time"
+)
+
+const DefaultWindowBuckets int64 = 168
+
+// now returns the current time; tests replace it to control the clock
+var now = time.Now
+
+// windowState holds the per-bucket totals of a windowed counter, keyed by the Unix time each bucket starts
+type windowState struct {
+	Bucket    time.Duration    `json:"bucket"`
+	Retention time.Duration    `json:"retention"`
+	Buckets   map[string]int64 `json:"buckets"`
+}
+
+// windowPath returns the file that stores the buckets of a counter
+func windowPath(filePath string) string {
+	return sidecarPath(filePath, "window")
+}
+
+// readWindow reads the buckets of a counter; ok is false when the counter has never been windowed
+func readWindow(filePath string) (state windowState, ok bool, err error) {
+	data, readErr := os.ReadFile(windowPath(filePath))
+	if readErr != nil {
+		if errors.Is(readErr, fs.ErrNotExist) {
+			return state, false, nil
+		}
+		return state, false, fmt.Errorf("failed to read counter window: %w", readErr)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, false, fmt.Errorf("invalid counter window: %w", err)
+	}
+	if state.Buckets == nil {
+		state.Buckets = make(map[string]int64)
+	}
+	return state, true, nil
+}
+
+// addToWindow adds amount to the current bucket of a counter and prunes buckets older than the retention;
+// the caller must hold the counter lock
+func addToWindow(filePath string, bucket, retention time.Duration, amount int64) error {
+	if bucket <= 0 {
+		return errors.New("window must be positive")
+	}
+	state, ok, readErr := readWindow(filePath)
+	if readErr != nil {
+		return readErr
+	}
+	if !ok {
+		state = windowState{Bucket: bucket, Buckets: make(map[string]int64)}
+	}
+	if state.Bucket != bucket {
+		return fmt.Errorf("counter is windowed into %s buckets, not %s", state.Bucket, bucket)
+	}
+	if retention > 0 {
+		state.Retention = retention
+	}
+	if state.Retention <= 0 {
+		state.Retention = time.Duration(DefaultWindowBuckets) * bucket
+	}
+
+	current := now().Truncate(bucket)
+	key := strconv.FormatInt(current.Unix(), 10)
+	state.Buckets[key] = addClamped(state.Buckets[key], amount)
+	oldest := current.Add(-state.Retention).Unix()
+	for start := range state.Buckets {
+		if unix, err := strconv.ParseInt(start, 10, 64); err != nil || unix < oldest {
+			delete(state.Buckets, start)
+		}
+	}
+
+	data, marshalErr := json.Marshal(state)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return writeFileAtomic(windowPath(filePath), data, 0600)
+}
+
+// sumWindow returns the total of the buckets that fall within the last span, including the current bucket
+func sumWindow(state windowState, span time.Duration) int64 {
+	count := int64(span / state.Bucket)
+	if span%state.Bucket != 0 {
+		count++
+	}
+	first := now().Truncate(state.Bucket).Add(-time.Duration(count-1) * state.Bucket).Unix()
+	var total int64
+	for start, value := range state.Buckets {
+		if unix, err := strconv.ParseInt(start, 10, 64); err == nil && unix >= first {
+			total = addClamped(total, value)
+		}
+	}
+	return total
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// useClock replaces now with a clock the test controls and returns the function that advances it
+func useClock(t *testing.T, start time.Time) func(time.Duration) {
+	t.Helper()
+	current := start
+	previous := now
+	now = func() time.Time { return current }
+	t.Cleanup(func() { now = previous })
+	return func(d time.Duration) { current = current.Add(d) }
+}
+
+// TestWindowBuckets tests the addToWindow and sumWindow functions
+func TestWindowBuckets(t *testing.T) {
+	useCounterDir(t)
+	advance := useClock(t, time.Date(2026, 1, 1, 10, 30, 0, 0, time.UTC))
+	path := counterPath("api.calls")
+
+	for hour := 0; hour < 30; hour++ {
+		if err := addToWindow(path, time.Hour, 48*time.Hour, 2); err != nil {
+			t.Fatalf("failed to add to window: %v", err)
+		}
+		advance(time.Hour)
+	}
+	advance(-time.Hour)
+
+	state, ok, err := readWindow(path)
+	if err != nil || !ok {
+		t.Fatalf("failed to read window: %v", err)
+	}
+	if got := sumWindow(state, 24*time.Hour); got != 48 {
+		t.Errorf("Expected 48 over the last 24 buckets, got %d", got)
+	}
+	if got := sumWindow(state, 90*time.Minute); got != 4 {
+		t.Errorf("Expected 4 over the last 2 buckets, got %d", got)
+	}
+	if got := sumWindow(state, 72*time.Hour); got != 60 {
+		t.Errorf("Expected 60 over all buckets, got %d", got)
+	}
+
+	advance(47 * time.Hour)
+	if err := addToWindow(path, time.Hour, 0, 1); err != nil {
+		t.Fatalf("failed to add to window: %v", err)
+	}
+	state, _, _ = readWindow(path)
+	if len(state.Buckets) != 3 {
+		t.Errorf("Expected 3 buckets within the retention, got %d", len(state.Buckets))
+	}
+	if err := addToWindow(path, time.Minute, 0, 1); err == nil {
+		t.Errorf("Expected an error when changing the bucket size")
+	}
+}
+
+// TestAddClamped tests the addClamped function
+func TestAddClamped(t *testing.T) {
+	const maxInt64, minInt64 = int64(1<<63 - 1), int64(-1 << 63)
+	if got := addClamped(maxInt64-1, 5); got != maxInt64 {
+		t.Errorf("Expected %d, got %d", maxInt64, got)
+	}
+	if got := addClamped(minInt64+1, -5); got != minInt64 {
+		t.Errorf("Expected %d, got %d", minInt64, got)
+	}
+	if got := addClamped(40, 2); got != 42 {
+		t.Errorf("Expected 42, got %d", got)
+	}
+}